@@ -0,0 +1,22 @@
+module github.com/dewey/go-screenupload
+
+go 1.25.0
+
+require (
+	github.com/atotto/clipboard v0.1.4
+	github.com/deckarep/gosx-notifier v0.0.0-20180201035817-e127226297fb
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
+	github.com/pkg/sftp v1.13.11
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/zalando/go-keyring v0.2.8
+	golang.org/x/crypto v0.54.0
+	golang.org/x/sys v0.47.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/danieljoos/wincred v1.2.3 // indirect
+	github.com/godbus/dbus/v5 v5.2.2 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+)
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"text/template"
+)
+
+// launchAgentLabel identifies the launchd job
+const launchAgentLabel = "com.github.dewey.go-screenupload"
+
+// launchAgentPlist is the property list of the LaunchAgent, the values are
+// XML escaped
+var launchAgentPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Binary}}</string>
+		<string>-config</string>
+		<string>{{xml .Config}}</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ProcessType</key>
+	<string>Background</string>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+// xmlEscape escapes s for use in XML text
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// launchAgentPaths returns the paths of the plist and the log file
+func launchAgentPaths() (plist, logFile string, err error) {
+	if runtime.GOOS != "darwin" {
+		return "", "", errors.New("LaunchAgents are only supported on macOS")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	plist = filepath.Join(home, "Library", "LaunchAgents", launchAgentLabel+".plist")
+	logFile = filepath.Join(home, "Library", "Logs", "go-screenupload.log")
+	return plist, logFile, nil
+}
+
+// installLaunchAgent writes a LaunchAgent starting the binary with the
+// config file at login and loads it
+func installLaunchAgent(configPath string) error {
+	plist, logFile, err := launchAgentPaths()
+	if err != nil {
+		return err
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	binary, err = filepath.EvalSymlinks(binary)
+	if err != nil {
+		return err
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = launchAgentPlist.Execute(&buf, map[string]string{
+		"Label":  launchAgentLabel,
+		"Binary": binary,
+		"Config": configPath,
+		"Log":    logFile,
+	})
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{filepath.Dir(plist), filepath.Dir(logFile)} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.WriteFile(plist, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	log.Println("wrote", plist)
+
+	// reload an already installed agent so changes take effect
+	exec.Command("launchctl", "unload", plist).Run()
+	out, err := exec.Command("launchctl", "load", "-w", plist).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl load failed: %v: %s", err, out)
+	}
+	log.Println("loaded the LaunchAgent, logs are written to", logFile)
+	return nil
+}
+
+// uninstallLaunchAgent unloads and removes the LaunchAgent
+func uninstallLaunchAgent() error {
+	plist, _, err := launchAgentPaths()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plist); os.IsNotExist(err) {
+		return fmt.Errorf("no LaunchAgent installed at %s", plist)
+	}
+	out, err := exec.Command("launchctl", "unload", "-w", plist).CombinedOutput()
+	if err != nil {
+		log.Printf("warning: launchctl unload failed: %v: %s", err, out)
+	}
+	err = os.Remove(plist)
+	if err != nil {
+		return err
+	}
+	log.Println("removed", plist)
+	return nil
+}
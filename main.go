@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dewey/go-screenupload/screenupload"
+)
+
+// Exit codes of the one-shot mode
+const (
+	exitOK       = 0 // The file was uploaded
+	exitConfig   = 3 // The configuration is invalid
+	exitNotFound = 4 // The file to upload doesn't exist
+	exitUpload   = 5 // The upload failed
+)
+
+func main() {
+	var (
+		configPath = flag.String("config", screenupload.DefaultConfigPath(), "path to the config file")
+		initConfig = flag.Bool("init", false, "write an example config file to the config path and exit")
+		force      = flag.Bool("force", false, "overwrite an existing config file with -init or -import")
+		runDoctor  = flag.Bool("doctor", false, "check the environment and configuration and exit")
+		secretRef  = flag.String("set-secret", "", "store a secret read from stdin in the OS keychain as `service/account` and exit")
+		file       = flag.String("file", "", "upload a single `file`, print its URL and exit")
+		latest     = flag.Bool("latest", false, "upload the newest file in the watch directory matching the filter, print its URL and exit")
+		reupload   = flag.String("reupload", "", "upload an archived `file` again without moving it, print its URL and exit")
+		keepName   = flag.Bool("keep-name", false, "keep the name of the archived file with -reupload")
+		installLA  = flag.Bool("install-launchagent", false, "install a macOS LaunchAgent starting the uploader with the config file at login and exit")
+		removeLA   = flag.Bool("uninstall-launchagent", false, "remove the macOS LaunchAgent and exit")
+		installSvc = flag.Bool("install-service", false, "install and start a systemd user service running the uploader with the config file and exit")
+		removeSvc  = flag.Bool("uninstall-service", false, "stop and remove the systemd user service and exit")
+		verify     = flag.Bool("verify-remote", false, "check that every archived file still exists on the remote side, print the missing ones and exit")
+		repair     = flag.Bool("repair", false, "upload missing files again with -verify-remote")
+		checkArch  = flag.Bool("check-archive", false, "hash the archived files again, print the ones which don't match the manifest and exit")
+		bench      = flag.Bool("benchmark", false, "upload a test file with the configured backend, print the latency and throughput and exit")
+		benchSize  = flag.Int("benchmark-size", 10, "size of the test file of -benchmark in `MB`")
+		printCfg   = flag.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+		exportCfg  = flag.String("export", "", "write the effective config to `file` for another machine, - for stdout, and exit")
+		exportSec  = flag.Bool("export-secrets", false, "include secrets and the values of keychain references with -export")
+		importCfg  = flag.String("import", "", "validate an exported config `file`, - for stdin, write it to the config path and exit")
+		noColor    = flag.Bool("no-color", os.Getenv("NO_COLOR") != "", "disable colored output, it is only used if stderr is a terminal")
+	)
+	flag.BoolVar(&screenupload.Debug, "debug", os.Getenv("DEBUG") == "true", "log which config files were loaded and the effective config")
+	flag.BoolVar(&screenupload.Quiet, "q", false, "with -file, -latest or -reupload, only print the URL and errors")
+	flag.BoolVar(&screenupload.Quiet, "quiet", false, "same as -q")
+	var tags tagsFlag
+	flag.Var(&tags, "tag", "add a `tag` to the upload with -file, -latest or -reupload, can be repeated")
+	optionFlags := screenupload.RegisterOptionFlags(flag.CommandLine)
+	flag.Parse()
+
+	screenupload.Color = !*noColor && isTerminal(os.Stderr)
+	screenupload.Quiet = screenupload.Quiet && (*file != "" || *latest || *reupload != "")
+	if screenupload.Quiet {
+		log.SetOutput(io.Discard)
+	}
+
+	for _, t := range tags {
+		if err := screenupload.ValidateTag(t); err != nil {
+			logError(err)
+			os.Exit(exitConfig)
+		}
+	}
+
+	if *secretRef != "" {
+		secret, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Fatal(err)
+		}
+		err = screenupload.SetSecret(*secretRef, strings.TrimRight(secret, "\r\n"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("stored secret, reference it as %s%s", screenupload.KeyringPrefix, strings.TrimPrefix(*secretRef, screenupload.KeyringPrefix))
+		return
+	}
+
+	if *installLA || *removeLA {
+		var err error
+		if *installLA {
+			err = installLaunchAgent(*configPath)
+		} else {
+			err = uninstallLaunchAgent()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *installSvc || *removeSvc {
+		var err error
+		if *installSvc {
+			err = installService(*configPath)
+		} else {
+			err = uninstallService()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *initConfig {
+		err := screenupload.WriteExampleConfig(*configPath, *force)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("wrote example config to", *configPath)
+		return
+	}
+
+	if *importCfg != "" {
+		var b []byte
+		var err error
+		if *importCfg == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(*importCfg)
+		}
+		if err == nil {
+			err = screenupload.ImportConfig(b, *configPath, *force)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("imported config to", *configPath)
+		return
+	}
+
+	cfg, err := screenupload.LoadConfig([]string{screenupload.SystemConfigPath, *configPath}, optionFlags())
+	if err != nil {
+		logError(err)
+		os.Exit(exitConfig)
+	}
+
+	if cfg.LogFile != "" && !screenupload.Quiet {
+		w, err := screenupload.OpenLogFile(cfg)
+		if err != nil {
+			logError(fmt.Errorf("failed to open LOG_FILE: %v", err))
+			os.Exit(exitConfig)
+		}
+		defer w.Close()
+		log.SetOutput(w)
+		screenupload.Color = false
+	}
+
+	if *printCfg {
+		fmt.Print(screenupload.FormatConfig(cfg))
+		return
+	}
+
+	if *exportCfg != "" {
+		out := screenupload.ExportConfig(cfg, *exportSec)
+		if *exportCfg == "-" {
+			fmt.Print(out)
+			return
+		}
+		err := os.WriteFile(*exportCfg, []byte(out), 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("exported config to", *exportCfg)
+		return
+	}
+
+	if *runDoctor {
+		if !screenupload.Doctor(cfg) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *checkArch {
+		problems, err := screenupload.CheckArchive(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", p.Path, p.Problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		log.Println("all archived files match the manifest")
+		return
+	}
+
+	if *verify {
+		os.Exit(verifyRemote(cfg, *repair))
+	}
+
+	if *bench {
+		os.Exit(benchmark(cfg, *benchSize))
+	}
+
+	if *file != "" {
+		os.Exit(uploadOnce(cfg, *file, tags, false, false))
+	}
+	if *latest {
+		path, err := screenupload.LatestFile(cfg)
+		if err != nil {
+			logError(err)
+			os.Exit(exitNotFound)
+		}
+		os.Exit(uploadOnce(cfg, path, tags, false, false))
+	}
+	if *reupload != "" {
+		os.Exit(uploadOnce(cfg, *reupload, tags, true, *keepName))
+	}
+
+	u, err := screenupload.Setup(&cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	watch(cfg, u)
+}
+
+// tagsFlag collects the values of a repeated flag
+type tagsFlag []string
+
+func (t *tagsFlag) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagsFlag) Set(v string) error {
+	*t = append(*t, v)
+	return nil
+}
+
+// logError logs an error, in quiet mode it is printed to stderr instead
+func logError(err error) {
+	if screenupload.Quiet {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return
+	}
+	log.Println(screenupload.Failure(err))
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// uploadOnce uploads a single file with the given tags, prints its URL and
+// returns the exit code. An archived file is uploaded in place, optionally
+// with its current name.
+func uploadOnce(c screenupload.Config, path string, tags []string, archived, keepName bool) (code int) {
+	u, err := screenupload.Setup(&c)
+	if err != nil {
+		logError(err)
+		return exitConfig
+	}
+	defer closeUploader(u, &code)
+
+	if _, err := os.Stat(path); err != nil {
+		logError(err)
+		return exitNotFound
+	}
+
+	// an explicitly given file is uploaded even if it is a symlink
+	c.FollowSymlinks = true
+	if err := screenupload.CheckOverrides(path); err != nil {
+		logError(err)
+		return exitConfig
+	}
+	f, ok := screenupload.NewFile(c, path)
+	if !ok {
+		logError(fmt.Errorf("%s can't be uploaded", path))
+		return exitNotFound
+	}
+	f.Tags = append(f.Tags, tags...)
+	if archived {
+		f.Archived = true
+		if keepName && f.NameOverride == "" {
+			f.NameOverride = f.Name
+		}
+	}
+
+	fn, err := screenupload.Upload(c, u, f)
+	if err != nil {
+		if !screenupload.Quiet {
+			screenupload.NotifyFailure(c, f, err)
+		}
+		logError(err)
+		return exitUpload
+	}
+	fmt.Println(fn.URL)
+	return exitOK
+}
+
+// closeUploader closes u once the command is done, it waits for uploads to
+// further BACKENDS and turns their failures into a failed exit code
+func closeUploader(u screenupload.Uploader, code *int) {
+	c, ok := u.(io.Closer)
+	if !ok {
+		return
+	}
+	err := c.Close()
+	if err != nil {
+		logError(err)
+		if *code == exitOK {
+			*code = exitUpload
+		}
+	}
+}
+
+// verifyRemote prints the archived files which are missing on the remote
+// side and returns the exit code, with repair they are uploaded again
+func verifyRemote(c screenupload.Config, repair bool) (code int) {
+	u, err := screenupload.Setup(&c)
+	if err != nil {
+		logError(err)
+		return exitConfig
+	}
+	defer closeUploader(u, &code)
+	missing, err := screenupload.VerifyArchive(c, u)
+	if err != nil {
+		logError(err)
+		return exitConfig
+	}
+	if len(missing) == 0 {
+		log.Println("all archived files exist on the remote side")
+		return exitOK
+	}
+
+	failed := 0
+	for _, f := range missing {
+		if !repair {
+			fmt.Println(f.Path)
+			continue
+		}
+		f.NameOverride = f.Name
+		_, err := screenupload.Upload(c, u, f)
+		if err != nil {
+			logError(fmt.Errorf("failed to upload %s again: %v", f.Path, err))
+			failed++
+		}
+	}
+	if !repair {
+		log.Printf("%d archived files are missing on the remote side, upload them again with -repair", len(missing))
+		return exitUpload
+	}
+	if failed > 0 {
+		return exitUpload
+	}
+	return exitOK
+}
+
+// benchmark uploads a test file of size megabytes, prints the result and
+// returns the exit code
+func benchmark(c screenupload.Config, size int) (code int) {
+	if size <= 0 {
+		logError(fmt.Errorf("invalid -benchmark-size %d", size))
+		return exitConfig
+	}
+	u, err := screenupload.Setup(&c)
+	if err != nil {
+		logError(err)
+		return exitConfig
+	}
+	defer closeUploader(u, &code)
+	log.Printf("uploading %d MB to %s", size, c.Backend)
+	res, err := screenupload.Benchmark(c, u, int64(size)<<20)
+	if err != nil {
+		logError(err)
+		return exitUpload
+	}
+	fmt.Printf("latency:    %s\n", res.Latency.Round(time.Millisecond))
+	fmt.Printf("upload:     %s\n", res.Duration.Round(time.Millisecond))
+	fmt.Printf("throughput: %.2f MB/s\n", res.Throughput())
+	if !res.Removed {
+		log.Println("the test files couldn't be removed, delete them on the server, e.g.", res.URL)
+	}
+	return exitOK
+}
+
+// watch uploads new files in the watch directory until it is stopped by a
+// signal, SIGUSR1 and SIGUSR2 pause and resume uploads
+func watch(cfg screenupload.Config, u screenupload.Uploader) {
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pause, resume := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	notifyPause(pause, resume)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for {
+			select {
+			case <-pause:
+				w.Pause()
+			case <-resume:
+				w.Resume()
+			case s := <-stop:
+				log.Println("received", s, "shutting down")
+				sdNotify("STOPPING=1")
+				w.Stop()
+				return
+			}
+		}
+	}()
+
+	// tell systemd once the watch directory is watched
+	go func() {
+		<-w.Ready()
+		err := sdNotify("READY=1")
+		if err != nil {
+			log.Println("warning: failed to notify systemd:", err)
+		}
+	}()
+
+	err = w.Start(context.Background())
+	if err != nil {
+		log.Fatal(screenupload.Failure(err))
+	}
+}
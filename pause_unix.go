@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyPause relays SIGUSR1 to pause and SIGUSR2 to resume
+func notifyPause(pause, resume chan<- os.Signal) {
+	signal.Notify(pause, syscall.SIGUSR1)
+	signal.Notify(resume, syscall.SIGUSR2)
+}
@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// notifyPause does nothing as there are no user signals on windows
+func notifyPause(pause, resume chan<- os.Signal) {}
@@ -0,0 +1,157 @@
+package screenupload
+
+import (
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// animator collects frames created within a window and assembles them into
+// an animated GIF which is uploaded instead of the individual frames. The
+// GIF is passed to the watcher, it is uploaded like any other new file.
+type animator struct {
+	cfg    Config
+	frames *regexp.Regexp
+	ready  chan File       // assembled animations
+	stop   <-chan struct{} // closed once the watcher stopped
+
+	mu    sync.Mutex
+	paths []string
+	timer *time.Timer
+}
+
+// newAnimator returns an animator collecting files matching frames, it
+// stops passing animations on once stop is closed
+func newAnimator(cfg Config, frames *regexp.Regexp, stop <-chan struct{}) *animator {
+	return &animator{cfg: cfg, frames: frames, ready: make(chan File), stop: stop}
+}
+
+// Match reports whether a file is a frame of an animation
+func (a *animator) Match(path string) bool {
+	return a.frames.MatchString(filepath.Base(path))
+}
+
+// Add adds a frame, the animation is assembled once no frame was added
+// for the length of the window
+func (a *animator) Add(path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.paths = append(a.paths, path)
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.timer = time.AfterFunc(a.cfg.GIFWindow, a.flush)
+}
+
+// Ready returns the channel receiving the assembled animations, the frames
+// are archived once the animation is uploaded
+func (a *animator) Ready() <-chan File {
+	return a.ready
+}
+
+// flush assembles the collected frames into a temporary GIF and passes it
+// to the watcher
+func (a *animator) flush() {
+	a.mu.Lock()
+	paths := a.paths
+	a.paths, a.timer = nil, nil
+	a.mu.Unlock()
+
+	sort.Strings(paths)
+	log.Printf("assembling %d frames into a gif", len(paths))
+
+	tmp, err := createTemp(a.cfg, "screenupload-*.gif")
+	if err != nil {
+		log.Println("failed to create gif:", err)
+		return
+	}
+
+	err = encodeGIF(tmp, paths, a.cfg.GIFDelay)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		removeTemp(tmp.Name())
+		log.Println("failed to create gif:", err)
+		return
+	}
+
+	select {
+	case a.ready <- File{Path: tmp.Name(), frames: paths}:
+	case <-a.stop:
+		removeTemp(tmp.Name())
+	}
+}
+
+// archiveFrames moves the frames of an uploaded animation into the archive,
+// without an archive or with Preserve they stay where they are
+func archiveFrames(cfg Config, paths []string) {
+	if cfg.Archive == "" || cfg.Preserve {
+		return
+	}
+	err := archiveDir(cfg)
+	if err == nil {
+		err = checkFreeSpace(cfg, cfg.Archive)
+	}
+	if err != nil {
+		log.Println("failed to archive frames:", err)
+		return
+	}
+	var archived []File
+	for _, p := range paths {
+		dir := filepath.Join(cfg.Archive, archiveRoute(cfg, File{Path: p, Extension: filepath.Ext(p)}))
+		dst := filepath.Join(dir, filepath.Base(p))
+		err := os.MkdirAll(dir, cfg.ArchiveDirMode)
+		if err == nil {
+			err = moveFile(cfg, p, dst)
+		}
+		if err == nil {
+			err = chmodArchived(cfg, dst)
+		}
+		if err != nil {
+			log.Println("failed to archive frame:", err)
+			continue
+		}
+		archived = append(archived, File{Path: dst})
+	}
+	updateManifest(cfg, archived, nil)
+	pruneArchive(cfg, "")
+}
+
+// encodeGIF writes the images at paths as frames of an animated GIF
+func encodeGIF(w *os.File, paths []string, delay time.Duration) error {
+	g := &gif.GIF{}
+	for _, p := range paths {
+		img, err := decodeImage(p)
+		if err != nil {
+			log.Printf("skipping frame %s: %v", p, err)
+			continue
+		}
+		frame := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(frame, img.Bounds(), img, image.Point{})
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, int(delay/(10*time.Millisecond)))
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// decodeImage decodes the image at path
+func decodeImage(path string) (image.Image, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	return img, err
+}
@@ -0,0 +1,298 @@
+package screenupload
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// archiveNameData is passed to ArchiveNameTemplate
+type archiveNameData struct {
+	OriginalName string    // Name of the file in the watch directory
+	Hash         string    // Generated name without the extension
+	Extension    string    // Extension of the file including the dot
+	Time         time.Time // Time of the upload
+}
+
+// archiveName returns the name of a file in the archive directory dir, it is
+// the uploaded name unless ArchiveNameTemplate is set. An existing file of
+// that name is never overwritten, the hash is appended instead.
+func archiveName(cfg Config, f File, hash, dir string) (string, error) {
+	if cfg.ArchiveNameTemplate == "" {
+		return hash + nameCase(cfg, f.Extension), nil
+	}
+	t, err := template.New("archive").Parse(cfg.ArchiveNameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid ARCHIVE_NAME_TEMPLATE: %v", err)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, archiveNameData{
+		OriginalName: f.Name,
+		Hash:         hash,
+		Extension:    f.Extension,
+		Time:         time.Now(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid ARCHIVE_NAME_TEMPLATE: %v", err)
+	}
+
+	name := strings.TrimSpace(buf.String())
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("ARCHIVE_NAME_TEMPLATE rendered the invalid file name %q", name)
+	}
+	if !strings.HasSuffix(name, f.Extension) {
+		name += f.Extension
+	}
+	if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+		name = strings.TrimSuffix(name, f.Extension) + "-" + hash + f.Extension
+	}
+	return name, nil
+}
+
+// archiveDir creates the archive directory if CreateArchiveDir is set,
+// otherwise it checks that it exists
+func archiveDir(cfg Config) error {
+	if cfg.CreateArchiveDir {
+		err := os.MkdirAll(cfg.Archive, cfg.ArchiveDirMode)
+		if err != nil {
+			return fmt.Errorf("failed to create the archive directory: %v", err)
+		}
+		return nil
+	}
+	info, err := os.Stat(cfg.Archive)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("the archive directory %s doesn't exist, create it or set CREATE_ARCHIVE_DIR", cfg.Archive)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("ARCHIVE %s is not a directory", cfg.Archive)
+	}
+	return nil
+}
+
+// archiveRoute returns the directory of a file relative to the archive. The
+// first of ArchiveRoutes matching its extension or content type wins, other
+// files go into ArchiveRouteDefault.
+func archiveRoute(cfg Config, f File) string {
+	if len(cfg.ArchiveRoutes) == 0 {
+		return ""
+	}
+	ext := strings.ToLower(f.Extension)
+	ctype := contentType(f)
+	for _, r := range cfg.ArchiveRoutes {
+		dir, pattern, err := parseArchiveRoute(r)
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(pattern, "."):
+			if pattern == ext {
+				return dir
+			}
+		case strings.HasSuffix(pattern, "/*"):
+			if strings.HasPrefix(ctype, strings.TrimSuffix(pattern, "*")) {
+				return dir
+			}
+		case pattern == ctype:
+			return dir
+		}
+	}
+	return filepath.FromSlash(cfg.ArchiveRouteDefault)
+}
+
+// parseArchiveRoute splits a route of the form dir=.ext, dir=type/subtype or
+// dir=type/*
+func parseArchiveRoute(r string) (dir, pattern string, err error) {
+	kv := strings.SplitN(r, "=", 2)
+	if len(kv) != 2 {
+		return "", "", fmt.Errorf("invalid ARCHIVE_ROUTES entry %q, expected dir=.ext or dir=type/*", r)
+	}
+	dir, err = archiveSubdir(strings.TrimSpace(kv[0]))
+	if err != nil || dir == "" {
+		return "", "", fmt.Errorf("invalid directory in ARCHIVE_ROUTES entry %q", r)
+	}
+	pattern = strings.ToLower(strings.TrimSpace(kv[1]))
+	if !strings.HasPrefix(pattern, ".") && !strings.Contains(pattern, "/") {
+		return "", "", fmt.Errorf("invalid ARCHIVE_ROUTES entry %q, expected dir=.ext or dir=type/*", r)
+	}
+	return dir, pattern, nil
+}
+
+// archiveSubdir checks that dir is relative and stays within the archive
+func archiveSubdir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	dir = filepath.Clean(filepath.FromSlash(dir))
+	if filepath.IsAbs(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not a directory within the archive", dir)
+	}
+	return dir, nil
+}
+
+// checkArchiveRoutes validates ArchiveRoutes and ArchiveRouteDefault
+func checkArchiveRoutes(cfg Config) error {
+	for _, r := range cfg.ArchiveRoutes {
+		if _, _, err := parseArchiveRoute(r); err != nil {
+			return err
+		}
+	}
+	if _, err := archiveSubdir(cfg.ArchiveRouteDefault); err != nil {
+		return fmt.Errorf("invalid ARCHIVE_ROUTE_DEFAULT: %v", err)
+	}
+	return nil
+}
+
+// contentType returns the content type of a file from its extension or, if
+// that is unknown, from its content
+func contentType(f File) string {
+	if t, _, err := mime.ParseMediaType(mime.TypeByExtension(f.Extension)); err == nil {
+		return t
+	}
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	t, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	return t
+}
+
+// archivedFile is a file in the archive or one of its subdirectories
+type archivedFile struct {
+	Path string // Path of the file
+	Key  string // Path relative to the archive with slashes
+	Info os.FileInfo
+}
+
+// archivedFiles returns the regular files in the archive and in the
+// directories of ArchiveRoutes, except the manifest. Other subdirectories
+// aren't ours and are left alone.
+func archivedFiles(cfg Config) ([]archivedFile, error) {
+	dirs := []string{""}
+	seen := map[string]bool{"": true}
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, r := range cfg.ArchiveRoutes {
+		if dir, _, err := parseArchiveRoute(r); err == nil {
+			add(dir)
+		}
+	}
+	if len(cfg.ArchiveRoutes) > 0 {
+		if dir, err := archiveSubdir(cfg.ArchiveRouteDefault); err == nil {
+			add(dir)
+		}
+	}
+
+	var files []archivedFile
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(cfg.Archive, dir))
+		if os.IsNotExist(err) && dir != "" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			p := filepath.Join(cfg.Archive, dir, e.Name())
+			key := archiveKey(cfg, p)
+			if !e.Type().IsRegular() || isManifest(key) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			files = append(files, archivedFile{Path: p, Key: key, Info: info})
+		}
+	}
+	return files, nil
+}
+
+// archiveKey returns the path of an archived file relative to the archive
+// with slashes, files in the archive directory itself are keyed by name
+func archiveKey(cfg Config, p string) string {
+	rel, err := filepath.Rel(cfg.Archive, p)
+	if err != nil {
+		return filepath.Base(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// checkFreeSpace returns an error if less than MinFreeSpace megabytes are
+// available on the file system of dir. A failed check only logs a warning.
+func checkFreeSpace(cfg Config, dir string) error {
+	if cfg.MinFreeSpace <= 0 {
+		return nil
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		log.Printf("warning: failed to check the free space of %s: %v", dir, err)
+		return nil
+	}
+	if free < uint64(cfg.MinFreeSpace)<<20 {
+		return fmt.Errorf("only %s free on the file system of %s, MIN_FREE_SPACE is %d MB", formatSize(int64(free)), dir, cfg.MinFreeSpace)
+	}
+	return nil
+}
+
+// pruneArchive removes the oldest files in the archive directory until only
+// ArchiveKeep files are left. The file at current is always kept and
+// counts towards the limit.
+func pruneArchive(cfg Config, current string) {
+	if cfg.Archive == "" || cfg.ArchiveKeep <= 0 {
+		return
+	}
+	entries, err := archivedFiles(cfg)
+	if err != nil {
+		log.Println("failed to prune archive:", err)
+		return
+	}
+
+	keep := cfg.ArchiveKeep
+	var files []archivedFile
+	for _, e := range entries {
+		if e.Path == filepath.Clean(current) {
+			keep--
+			continue
+		}
+		files = append(files, e)
+	}
+	if len(files) <= keep {
+		return
+	}
+
+	// newest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Info.ModTime().After(files[j].Info.ModTime())
+	})
+	var removed []string
+	for _, e := range files[keep:] {
+		p := e.Path
+		err := os.Remove(p)
+		if err != nil {
+			log.Println("failed to prune archive:", err)
+			continue
+		}
+		log.Println("removed", p, "from the archive")
+		removed = append(removed, p)
+	}
+	updateManifest(cfg, nil, removed)
+}
@@ -0,0 +1,378 @@
+package screenupload
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// b2AuthorizeURL is the endpoint of b2_authorize_account
+var b2AuthorizeURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+
+// b2Timeout bounds a single B2 request
+const b2Timeout = 5 * time.Minute
+
+// b2UploadAttempts is how often an upload is tried with a fresh upload URL
+const b2UploadAttempts = 3
+
+// B2Uploader uploads files to a Backblaze B2 bucket using the native API
+type B2Uploader struct {
+	cfg    Config
+	client *http.Client
+	url    *template.Template
+
+	mu          sync.Mutex
+	accountID   string
+	apiURL      string
+	downloadURL string
+	token       string // account authorization token
+	bucketID    string
+	uploadURL   string
+	uploadToken string
+}
+
+// b2URLData is passed to the URL template of the b2 backend
+type b2URLData struct {
+	Name        string // Name of the uploaded file
+	Path        string // Name of the file in the bucket
+	Bucket      string // Name of the bucket
+	DownloadURL string // Download URL of the account, e.g. https://f002.backblazeb2.com
+}
+
+// b2Error is the body of a failed B2 request
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("b2: %s: %s", e.Code, e.Message)
+}
+
+// NewB2Uploader returns a B2Uploader uploading into B2Bucket
+func NewB2Uploader(cfg Config) (*B2Uploader, error) {
+	err := checkB2Parts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newHTTPClient(cfg, b2Timeout)
+	if err != nil {
+		return nil, err
+	}
+	u := &B2Uploader{cfg: cfg, client: client}
+	if cfg.B2URLTemplate != "" {
+		t, err := template.New("url").Parse(cfg.B2URLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid B2_URL_TEMPLATE: %v", err)
+		}
+		u.url = t
+	}
+	return u, nil
+}
+
+// Upload uploads a file into the bucket, the upload URL is renewed and the
+// upload retried if B2 asks for it. Large files are uploaded in parts.
+func (u *B2Uploader) Upload(f File) error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	if u.large(info.Size()) {
+		return u.uploadLarge(f, info.Size())
+	}
+
+	sum, err := sha1File(f.Path)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = u.upload(f, sum)
+		if err == nil || attempt == b2UploadAttempts || !b2Retryable(err) {
+			return err
+		}
+		log.Printf("warning: b2 upload of %s failed, retrying with a new upload URL: %v", f.Name, err)
+		u.mu.Lock()
+		u.uploadURL, u.uploadToken = "", ""
+		if e, ok := err.(*b2Error); ok && e.Code == "expired_auth_token" {
+			u.token = ""
+		}
+		u.mu.Unlock()
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// URL returns the URL of an uploaded file using the URL template, RUrl or
+// the download URL of the bucket
+func (u *B2Uploader) URL(f File) string {
+	u.mu.Lock()
+	data := b2URLData{
+		Name:        f.Name,
+		Path:        u.fileName(f),
+		Bucket:      u.cfg.B2Bucket,
+		DownloadURL: u.downloadURL,
+	}
+	u.mu.Unlock()
+
+	if u.url != nil {
+		var buf bytes.Buffer
+		err := u.url.Execute(&buf, data)
+		if err != nil {
+			log.Println("failed to render B2_URL_TEMPLATE:", err)
+		}
+		return buf.String()
+	}
+	if remoteURL(u.cfg, f) != "" {
+		return fmt.Sprintf("%s/%s", remoteURL(u.cfg, f), f.Name)
+	}
+	return fmt.Sprintf("%s/file/%s/%s", data.DownloadURL, data.Bucket, data.Path)
+}
+
+// Missing returns the files which don't exist in the bucket
+func (u *B2Uploader) Missing(files []File) ([]File, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	err := u.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []File
+	for _, f := range files {
+		name := u.fileName(f)
+		var res struct {
+			Files []struct {
+				FileName string `json:"fileName"`
+			} `json:"files"`
+		}
+		err := u.call("b2_list_file_names", map[string]interface{}{"bucketId": u.bucketID, "startFileName": name, "maxFileCount": 1}, &res)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Files) == 0 || res.Files[0].FileName != name {
+			missing = append(missing, f)
+		}
+	}
+	return missing, nil
+}
+
+// fileName returns the name of a file in the bucket, RPath is its directory
+func (u *B2Uploader) fileName(f File) string {
+	return strings.TrimPrefix(path.Join(remotePath(u.cfg, f), f.Name), "/")
+}
+
+// upload makes a single upload attempt
+func (u *B2Uploader) upload(f File, sum string) error {
+	uploadURL, token, err := u.getUploadURL()
+	if err != nil {
+		return err
+	}
+
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	info, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", uploadURL, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Authorization", token)
+	req.Header.Set("X-Bz-File-Name", b2EscapeName(u.fileName(f)))
+	req.Header.Set("Content-Type", "b2/x-auto")
+	req.Header.Set("X-Bz-Content-Sha1", sum)
+	if len(f.Tags) > 0 {
+		req.Header.Set("X-Bz-Info-tags", strings.Join(f.Tags, ","))
+	}
+	return u.do(req, nil)
+}
+
+// getUploadURL authorizes the account if necessary and returns an upload URL
+// with its token, it is reused until an upload fails
+func (u *B2Uploader) getUploadURL() (string, string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.uploadURL != "" {
+		return u.uploadURL, u.uploadToken, nil
+	}
+
+	err := u.prepare()
+	if err != nil {
+		return "", "", err
+	}
+
+	var res struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	err = u.call("b2_get_upload_url", map[string]string{"bucketId": u.bucketID}, &res)
+	if err != nil {
+		return "", "", err
+	}
+	u.uploadURL, u.uploadToken = res.UploadURL, res.AuthorizationToken
+	return u.uploadURL, u.uploadToken, nil
+}
+
+// prepare authorizes the account and looks up the bucket if necessary, the
+// caller holds mu
+func (u *B2Uploader) prepare() error {
+	if u.token == "" {
+		err := u.authorize()
+		if err != nil {
+			return err
+		}
+	}
+	if u.bucketID == "" {
+		var res struct {
+			Buckets []struct {
+				BucketID string `json:"bucketId"`
+			} `json:"buckets"`
+		}
+		err := u.call("b2_list_buckets", map[string]string{"accountId": u.accountID, "bucketName": u.cfg.B2Bucket}, &res)
+		if err != nil {
+			return err
+		}
+		if len(res.Buckets) == 0 {
+			return fmt.Errorf("b2: bucket %s not found", u.cfg.B2Bucket)
+		}
+		u.bucketID = res.Buckets[0].BucketID
+	}
+	return nil
+}
+
+// authorize gets an account authorization token, the caller holds mu
+func (u *B2Uploader) authorize() error {
+	req, err := http.NewRequest("GET", b2AuthorizeURL, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(u.cfg.B2KeyID, u.cfg.B2ApplicationKey)
+	var res struct {
+		AccountID          string `json:"accountId"`
+		AuthorizationToken string `json:"authorizationToken"`
+		APIURL             string `json:"apiUrl"`
+		DownloadURL        string `json:"downloadUrl"`
+		Allowed            struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"allowed"`
+	}
+	err = u.do(req, &res)
+	if err != nil {
+		return err
+	}
+	u.accountID = res.AccountID
+	u.token = res.AuthorizationToken
+	u.apiURL = res.APIURL
+	u.downloadURL = res.DownloadURL
+	// keys restricted to a bucket can't list buckets
+	if res.Allowed.BucketID != "" && res.Allowed.BucketName == u.cfg.B2Bucket {
+		u.bucketID = res.Allowed.BucketID
+	}
+	return nil
+}
+
+// call calls an API operation with the account authorization token, the
+// caller holds mu
+func (u *B2Uploader) call(operation string, body, res interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", u.apiURL+"/b2api/v2/"+operation, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", u.token)
+	err = u.do(req, res)
+	if e, ok := err.(*b2Error); ok && e.Code == "expired_auth_token" {
+		u.token = ""
+	}
+	return err
+}
+
+// do sends a request and decodes the response into res, failed requests
+// return a *b2Error
+func (u *B2Uploader) do(req *http.Request, res interface{}) error {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := &b2Error{Status: resp.StatusCode, Code: resp.Status}
+		err := json.NewDecoder(resp.Body).Decode(e)
+		if err != nil {
+			e.Message = "unexpected response"
+		}
+		return e
+	}
+	if res == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// b2Retryable reports whether an upload should be retried with a new upload
+// URL, see https://www.backblaze.com/docs/cloud-storage-upload-files-with-the-native-api
+func b2Retryable(err error) bool {
+	var e *b2Error
+	if !errors.As(err, &e) {
+		// network errors
+		return true
+	}
+	switch e.Status {
+	case http.StatusUnauthorized:
+		return e.Code == "expired_auth_token"
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return e.Status >= 500
+}
+
+// b2EscapeName percent-encodes a file name for the X-Bz-File-Name header,
+// slashes are kept
+func b2EscapeName(name string) string {
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// sha1File returns the hex encoded SHA1 checksum of a file
+func sha1File(p string) (string, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha1.New()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,267 @@
+package screenupload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testB2Server is a B2 API which keeps uploads in memory, failPart decides
+// whether an upload of a part fails with a 503
+type testB2Server struct {
+	*httptest.Server
+	failPart func(part, attempt int) bool
+
+	mu       sync.Mutex
+	calls    []string
+	files    map[string][]byte // finished files by name
+	parts    map[int][]byte
+	attempts map[int]int
+}
+
+// newTestB2Server starts a B2 API and points b2_authorize_account at it
+func newTestB2Server(t *testing.T) *testB2Server {
+	s := &testB2Server{files: make(map[string][]byte), parts: make(map[int][]byte), attempts: make(map[int]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	authorize, delay := b2AuthorizeURL, b2RetryDelay
+	b2AuthorizeURL, b2RetryDelay = s.URL+"/b2api/v2/b2_authorize_account", time.Millisecond
+	t.Cleanup(func() { b2AuthorizeURL, b2RetryDelay = authorize, delay })
+	return s
+}
+
+// Calls returns the API operations called, oldest first
+func (s *testB2Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// File returns the content of a file in the bucket
+func (s *testB2Server) File(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	return b, ok
+}
+
+func (s *testB2Server) handle(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.URL.Path, "/b2api/v2/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, strings.SplitN(operation, "/", 2)[0])
+
+	var body map[string]interface{}
+	if strings.HasPrefix(operation, "b2_") {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	reply := func(v interface{}) { json.NewEncoder(w).Encode(v) }
+
+	switch {
+	case operation == "b2_authorize_account":
+		reply(map[string]interface{}{
+			"accountId":          "account",
+			"authorizationToken": "token",
+			"apiUrl":             s.URL,
+			"downloadUrl":        s.URL,
+			"allowed":            map[string]string{"bucketId": "bucket-id", "bucketName": "bucket"},
+		})
+	case operation == "b2_get_upload_url":
+		reply(map[string]string{"uploadUrl": s.URL + "/b2api/v2/upload_file", "authorizationToken": "upload"})
+	case operation == "upload_file":
+		b, _ := io.ReadAll(r.Body)
+		name := r.Header.Get("X-Bz-File-Name")
+		s.files[name] = b
+		reply(map[string]string{"fileName": name})
+	case operation == "b2_start_large_file":
+		s.files[body["fileName"].(string)] = nil
+		reply(map[string]string{"fileId": body["fileName"].(string)})
+	case operation == "b2_get_upload_part_url":
+		reply(map[string]string{"uploadUrl": s.URL + "/b2api/v2/upload_part/" + body["fileId"].(string), "authorizationToken": "part"})
+	case strings.HasPrefix(operation, "upload_part/"):
+		part, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		s.attempts[part]++
+		b, _ := io.ReadAll(r.Body)
+		sum := sha1.Sum(b)
+		if s.failPart != nil && s.failPart(part, s.attempts[part]) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			reply(b2Error{Status: http.StatusServiceUnavailable, Code: "service_unavailable", Message: "busy"})
+			return
+		}
+		if hex.EncodeToString(sum[:]) != r.Header.Get("X-Bz-Content-Sha1") {
+			w.WriteHeader(http.StatusBadRequest)
+			reply(b2Error{Status: http.StatusBadRequest, Code: "bad_request", Message: "checksum did not match"})
+			return
+		}
+		s.parts[part] = b
+		reply(map[string]int{"partNumber": part})
+	case operation == "b2_finish_large_file":
+		var buf bytes.Buffer
+		for i, sum := range body["partSha1Array"].([]interface{}) {
+			b := s.parts[i+1]
+			got := sha1.Sum(b)
+			if hex.EncodeToString(got[:]) != sum {
+				w.WriteHeader(http.StatusBadRequest)
+				reply(b2Error{Status: http.StatusBadRequest, Code: "bad_request", Message: fmt.Sprintf("part %d: checksum did not match", i+1)})
+				return
+			}
+			buf.Write(b)
+		}
+		s.files[body["fileId"].(string)] = buf.Bytes()
+		reply(map[string]string{"fileId": body["fileId"].(string)})
+	case operation == "b2_cancel_large_file":
+		delete(s.files, body["fileId"].(string))
+		reply(map[string]string{"fileId": body["fileId"].(string)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		reply(b2Error{Status: http.StatusNotFound, Code: "not_found", Message: operation})
+	}
+}
+
+// testB2Config returns a config uploading files of at least 10 megabytes
+// in parts of 5 megabytes
+func testB2Config() Config {
+	cfg := DefaultConfig()
+	cfg.Backend = "b2"
+	cfg.B2KeyID, cfg.B2ApplicationKey, cfg.B2Bucket = "key", "secret", "bucket"
+	cfg.RPath = "shots"
+	cfg.B2LargeFileSize = 10
+	cfg.B2PartSize = 5
+	cfg.B2PartUploads = 2
+	return cfg
+}
+
+// testB2File writes a file of size random bytes
+func testB2File(t *testing.T, name string, size int) (File, []byte) {
+	t.Helper()
+	content := make([]byte, size)
+	rand.Read(content)
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return File{Path: path, Extension: filepath.Ext(name), Name: name, Size: int64(size)}, content
+}
+
+func countCalls(calls []string, operation string) int {
+	n := 0
+	for _, c := range calls {
+		if c == operation {
+			n++
+		}
+	}
+	return n
+}
+
+func TestB2UploadSmallFile(t *testing.T) {
+	server := newTestB2Server(t)
+	u, err := NewB2Uploader(testB2Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, content := testB2File(t, "shot.png", 1024)
+	err = u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.File("shots/shot.png"); !bytes.Equal(got, content) {
+		t.Errorf("uploaded %d bytes, want %d", len(got), len(content))
+	}
+	if n := countCalls(server.Calls(), "b2_start_large_file"); n != 0 {
+		t.Errorf("small file uploaded as a large file")
+	}
+}
+
+func TestB2UploadLargeFile(t *testing.T) {
+	server := newTestB2Server(t)
+	// the second part fails once and is retried on its own
+	server.failPart = func(part, attempt int) bool { return part == 2 && attempt == 1 }
+	u, err := NewB2Uploader(testB2Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, content := testB2File(t, "recording.mov", 12<<20)
+	err = u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := server.File("shots/recording.mov"); !bytes.Equal(got, content) {
+		t.Errorf("assembled %d bytes, want %d", len(got), len(content))
+	}
+	calls := server.Calls()
+	if n := countCalls(calls, "upload_part"); n != 4 {
+		t.Errorf("%d part uploads, want 3 parts and a retry", n)
+	}
+	if n := countCalls(calls, "upload_file"); n != 0 {
+		t.Errorf("large file uploaded at once")
+	}
+	if n := countCalls(calls, "b2_cancel_large_file"); n != 0 {
+		t.Errorf("successful upload cancelled")
+	}
+}
+
+func TestB2UploadLargeFileCancelled(t *testing.T) {
+	server := newTestB2Server(t)
+	server.failPart = func(part, attempt int) bool { return part == 3 }
+	u, err := NewB2Uploader(testB2Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := testB2File(t, "recording.mov", 12<<20)
+	err = u.Upload(f)
+	if err == nil || !strings.Contains(err.Error(), "part 3 of 3") {
+		t.Fatalf("got %v", err)
+	}
+
+	calls := server.Calls()
+	if n := countCalls(calls, "b2_cancel_large_file"); n != 1 {
+		t.Error("the large file wasn't cancelled")
+	}
+	if n := countCalls(calls, "b2_finish_large_file"); n != 0 {
+		t.Error("the failed large file was finished")
+	}
+	if _, ok := server.File("shots/recording.mov"); ok {
+		t.Error("the failed large file is in the bucket")
+	}
+}
+
+func TestB2PartSize(t *testing.T) {
+	cfg := testB2Config()
+	cfg.B2PartSize = 4
+	if _, err := NewB2Uploader(cfg); err == nil {
+		t.Error("parts of 4 megabytes accepted")
+	}
+	cfg.B2LargeFileSize = 0
+	if _, err := NewB2Uploader(cfg); err != nil {
+		t.Errorf("part size checked without large files: %v", err)
+	}
+}
+
+func TestB2LargeFileSizeNeedsTwoParts(t *testing.T) {
+	cfg := testB2Config()
+	cfg.B2PartSize = 100
+	cfg.B2LargeFileSize = 150
+	if _, err := NewB2Uploader(cfg); err == nil {
+		t.Error("large files of a single part accepted")
+	}
+	cfg.B2LargeFileSize = 200
+	if _, err := NewB2Uploader(cfg); err != nil {
+		t.Errorf("large files of 2 parts rejected: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package screenupload
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// b2MinPartSize is the smallest part B2 accepts, except for the last one
+const b2MinPartSize = 5 << 20
+
+// b2RetryDelay is the delay before the first retry of a failed part, it
+// grows with every attempt
+var b2RetryDelay = time.Second
+
+// checkB2Parts checks the options of large file uploads
+func checkB2Parts(cfg Config) error {
+	if cfg.B2LargeFileSize <= 0 {
+		return nil
+	}
+	if int64(cfg.B2PartSize)<<20 < b2MinPartSize {
+		return fmt.Errorf("B2_PART_SIZE has to be at least %d megabytes", b2MinPartSize>>20)
+	}
+	// B2 doesn't finish a large file of a single part
+	if cfg.B2LargeFileSize < 2*cfg.B2PartSize {
+		return fmt.Errorf("B2_LARGE_FILE_SIZE has to be at least twice B2_PART_SIZE, a large file needs 2 parts")
+	}
+	if cfg.B2PartUploads < 1 {
+		return fmt.Errorf("B2_PART_UPLOADS has to be at least 1")
+	}
+	return nil
+}
+
+// large reports whether a file of the given size is uploaded in parts
+func (u *B2Uploader) large(size int64) bool {
+	return u.cfg.B2LargeFileSize > 0 && size >= int64(u.cfg.B2LargeFileSize)<<20
+}
+
+// uploadLarge uploads a file in parts of B2PartSize, B2PartUploads of them
+// at a time. The large file is cancelled if a part can't be uploaded, so
+// its parts don't stay in the bucket.
+func (u *B2Uploader) uploadLarge(f File, size int64) error {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	fileInfo := map[string]string{}
+	if len(f.Tags) > 0 {
+		fileInfo["tags"] = strings.Join(f.Tags, ",")
+	}
+	var start struct {
+		FileID string `json:"fileId"`
+	}
+	u.mu.Lock()
+	err = u.prepare()
+	if err == nil {
+		err = u.call("b2_start_large_file", map[string]interface{}{
+			"bucketId":    u.bucketID,
+			"fileName":    u.fileName(f),
+			"contentType": "b2/x-auto",
+			"fileInfo":    fileInfo,
+		}, &start)
+	}
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	partSize := int64(u.cfg.B2PartSize) << 20
+	parts := int((size + partSize - 1) / partSize)
+	sums := make([]string, parts)
+	debugf("b2: uploading %s in %d parts", f.Name, parts)
+
+	jobs := make(chan int)
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	var partErr error
+	var wg sync.WaitGroup
+	for i := 0; i < u.cfg.B2PartUploads && i < parts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range jobs {
+				length := min(partSize, size-int64(part)*partSize)
+				sum, err := u.uploadPart(start.FileID, io.NewSectionReader(r, int64(part)*partSize, length), part+1)
+				if err != nil {
+					failOnce.Do(func() {
+						partErr = fmt.Errorf("part %d of %d: %v", part+1, parts, err)
+						close(failed)
+					})
+					return
+				}
+				sums[part] = sum
+			}
+		}()
+	}
+send:
+	for part := 0; part < parts; part++ {
+		select {
+		case jobs <- part:
+		case <-failed:
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if partErr != nil {
+		u.cancelLarge(f, start.FileID)
+		return partErr
+	}
+	u.mu.Lock()
+	err = u.call("b2_finish_large_file", map[string]interface{}{"fileId": start.FileID, "partSha1Array": sums}, nil)
+	u.mu.Unlock()
+	if err != nil {
+		u.cancelLarge(f, start.FileID)
+	}
+	return err
+}
+
+// uploadPart uploads a part of a large file and returns its SHA1 checksum,
+// it is retried with a new upload URL if B2 asks for it
+func (u *B2Uploader) uploadPart(fileID string, r *io.SectionReader, part int) (string, error) {
+	h := sha1.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	for attempt := 1; ; attempt++ {
+		err = u.sendPart(fileID, r, part, sum)
+		if err == nil || attempt == b2UploadAttempts || !b2Retryable(err) {
+			return sum, err
+		}
+		log.Printf("warning: b2 upload of part %d failed, retrying with a new upload URL: %v", part, err)
+		time.Sleep(time.Duration(attempt) * b2RetryDelay)
+	}
+}
+
+// sendPart makes a single attempt to upload a part on an upload URL of its
+// own, B2 doesn't allow parallel uploads to the same URL
+func (u *B2Uploader) sendPart(fileID string, r *io.SectionReader, part int, sum string) error {
+	var res struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	u.mu.Lock()
+	err := u.prepare()
+	if err == nil {
+		err = u.call("b2_get_upload_part_url", map[string]string{"fileId": fileID}, &res)
+	}
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", res.UploadURL, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = r.Size()
+	req.Header.Set("Authorization", res.AuthorizationToken)
+	req.Header.Set("X-Bz-Part-Number", fmt.Sprint(part))
+	req.Header.Set("X-Bz-Content-Sha1", sum)
+	return u.do(req, nil)
+}
+
+// cancelLarge cancels an unfinished large file and deletes its uploaded
+// parts, failures are only logged. The caller must not hold mu.
+func (u *B2Uploader) cancelLarge(f File, fileID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	err := u.call("b2_cancel_large_file", map[string]string{"fileId": fileID}, nil)
+	if err != nil {
+		log.Printf("warning: failed to cancel the large file upload of %s, its parts stay in the bucket: %v", f.Name, err)
+		return
+	}
+	debugf("b2: cancelled the large file upload of %s", f.Name)
+}
@@ -0,0 +1,94 @@
+package screenupload
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchItem is an upload waiting for its notification
+type batchItem struct {
+	f    File
+	name string // original name of the file
+	took time.Duration
+	clip string
+}
+
+// batcher coalesces the notifications of uploads finished within a window
+// into a single summary notification
+type batcher struct {
+	cfg       Config
+	window    time.Duration
+	threshold int
+
+	mu    sync.Mutex
+	items []batchItem
+	timer *time.Timer
+}
+
+// newBatcher returns a batcher which summarizes at least
+// NotifyBatchThreshold uploads finished within NotifyBatchWindow
+func newBatcher(cfg Config) *batcher {
+	return &batcher{cfg: cfg, window: cfg.NotifyBatchWindow, threshold: cfg.NotifyBatchThreshold}
+}
+
+// Add adds an upload, the window starts with the first upload of a batch
+func (b *batcher) Add(f File, originalName string, took time.Duration, clip string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items = append(b.items, batchItem{f: f, name: originalName, took: took, clip: clip})
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+}
+
+// flush notifies about the collected uploads, individually if there are
+// fewer than threshold of them
+func (b *batcher) flush() {
+	b.mu.Lock()
+	items := b.items
+	b.items, b.timer = nil, nil
+	b.mu.Unlock()
+
+	if len(items) < b.threshold {
+		for _, i := range items {
+			if i.clip != "" {
+				copyToClipboard(i.clip)
+			}
+			err := notify(b.cfg, i.f, i.name, i.took)
+			if err != nil {
+				log.Println("error:", err)
+			}
+		}
+		return
+	}
+
+	var clips []string
+	for _, i := range items {
+		if i.clip != "" {
+			clips = append(clips, i.clip)
+		}
+	}
+	if len(clips) > 0 {
+		copyToClipboard(strings.Join(clips, "\n"))
+	}
+
+	err := notifySummary(b.cfg, items)
+	if err != nil {
+		log.Println("error:", err)
+	}
+}
+
+// notifySummary sends one notification for a batch of uploads
+func notifySummary(cfg Config, items []batchItem) error {
+	d := notificationData{Count: len(items)}
+	urls := make([]string, len(items))
+	for n, i := range items {
+		d.Size += i.f.Size
+		d.Duration += i.took
+		urls[n] = i.f.URL
+	}
+	d.URL = strings.Join(urls, "\n")
+	return pushNotification(cfg, d)
+}
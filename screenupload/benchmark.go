@@ -0,0 +1,111 @@
+package screenupload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// benchmarkLatencySize is the size of the file whose upload time is
+// reported as latency
+const benchmarkLatencySize = 1
+
+// BenchmarkResult is the outcome of Benchmark
+type BenchmarkResult struct {
+	Size     int64         // Size of the test file in bytes
+	Latency  time.Duration // Time to upload a file of a single byte
+	Duration time.Duration // Time to upload the test file
+	URL      string        // URL of the test file, it is left on the server if it couldn't be removed
+	Removed  bool          // The test files were removed from the server
+}
+
+// Throughput returns the upload speed in MB/s
+func (r BenchmarkResult) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Size) / (1 << 20) / r.Duration.Seconds()
+}
+
+// Benchmark uploads a tiny and a file of size random bytes with the
+// uploader and times both uploads. The test files are removed again if the
+// uploader is a Remover.
+func Benchmark(cfg Config, u Uploader, size int64) (BenchmarkResult, error) {
+	res := BenchmarkResult{Size: size}
+
+	tiny, err := benchmarkFile(cfg, benchmarkLatencySize)
+	if err != nil {
+		return res, err
+	}
+	defer removeTemp(tiny.Path)
+	start := time.Now()
+	err = u.Upload(tiny)
+	if err != nil {
+		return res, err
+	}
+	res.Latency = time.Since(start)
+	removed := removeBenchmarkFile(u, tiny)
+
+	f, err := benchmarkFile(cfg, size)
+	if err != nil {
+		return res, err
+	}
+	defer removeTemp(f.Path)
+	start = time.Now()
+	err = u.Upload(f)
+	if err != nil {
+		return res, err
+	}
+	res.Duration = time.Since(start)
+	res.URL = u.URL(f)
+	res.Removed = removeBenchmarkFile(u, f) && removed
+	return res, nil
+}
+
+// benchmarkFile writes a temporary file of random bytes, they don't
+// compress so SSH compression can't skew the result
+func benchmarkFile(cfg Config, size int64) (File, error) {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return File{}, err
+	}
+	tmp, err := createTemp(cfg, "screenupload-benchmark-*.bin")
+	if err != nil {
+		return File{}, err
+	}
+	_, err = io.CopyN(tmp, rand.Reader, size)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		removeTemp(tmp.Name())
+		return File{}, fmt.Errorf("failed to create the test file: %v", err)
+	}
+	return File{
+		Path:      tmp.Name(),
+		Extension: ".bin",
+		Name:      "screenupload-benchmark-" + hex.EncodeToString(suffix) + ".bin",
+		Size:      size,
+	}, nil
+}
+
+// removeBenchmarkFile deletes a test file from the server and reports
+// whether it was removed
+func removeBenchmarkFile(u Uploader, f File) bool {
+	r, ok := u.(Remover)
+	if !ok {
+		return false
+	}
+	err := r.Remove(f)
+	if err != nil {
+		log.Println("warning: failed to remove the test file:", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package screenupload
+
+import "log"
+
+// breaker is a circuit breaker which stops uploads after BreakerFailures
+// consecutive failures. While it is open new files are held, a probe
+// decides when uploads go on.
+type breaker struct {
+	max      int // consecutive failures which open the circuit, disabled if zero
+	failures int
+	open     bool
+	probing  bool // the next outcome decides whether the circuit closes
+}
+
+// Open reports whether uploads are stopped
+func (b *breaker) Open() bool {
+	return b.open
+}
+
+// Record counts the outcome of an upload, it reports true if the failure
+// opened the circuit
+func (b *breaker) Record(err error) bool {
+	if b.max <= 0 {
+		return false
+	}
+	probing := b.probing
+	b.probing = false
+	if err == nil {
+		if probing {
+			log.Println("circuit breaker: the probe succeeded, uploading again")
+		}
+		b.failures = 0
+		b.open = false
+		return false
+	}
+	b.failures++
+	if b.failures < b.max || b.open {
+		return false
+	}
+	b.open = true
+	return true
+}
+
+// HalfOpen lets the next upload through to probe the server, a failure
+// opens the circuit again and a success closes it
+func (b *breaker) HalfOpen() {
+	log.Println("circuit breaker: probing the server")
+	b.open = false
+	b.probing = true
+	b.failures = b.max - 1
+}
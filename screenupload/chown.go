@@ -0,0 +1,102 @@
+package screenupload
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// chownRemote changes the owner and group of an uploaded file to
+// RemoteOwner and RemoteGroup. Numeric IDs are set via SFTP, which works
+// without a shell on the server, names are resolved by running chown.
+func chownRemote(cfg Config, client *ssh.Client, f File) error {
+	dst := path.Join(remotePath(cfg, f), f.Name)
+	spec := cfg.RemoteOwner
+	if cfg.RemoteGroup != "" {
+		spec += ":" + cfg.RemoteGroup
+	}
+
+	var err error
+	if uid, gid, ok := numericOwner(cfg); ok {
+		err = chownSFTP(client, dst, uid, gid)
+	} else {
+		err = chownCommand(client, dst, spec)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to change the owner of %s to %s, the SSH user needs permission to chown it: %v", dst, spec, err)
+	}
+	return nil
+}
+
+// numericOwner returns RemoteOwner and RemoteGroup as IDs, an empty value
+// is -1. It reports false if one of them is a name.
+func numericOwner(cfg Config) (uid, gid int, ok bool) {
+	uid, gid = -1, -1
+	var err error
+	if cfg.RemoteOwner != "" {
+		uid, err = strconv.Atoi(cfg.RemoteOwner)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	if cfg.RemoteGroup != "" {
+		gid, err = strconv.Atoi(cfg.RemoteGroup)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	return uid, gid, true
+}
+
+// chownSFTP sets the owner and group of a remote file, an ID of -1 keeps
+// the current one
+func chownSFTP(client *ssh.Client, dst string, uid, gid int) error {
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %v", err)
+	}
+	defer c.Close()
+
+	// SFTP always sets both, so the unchanged one is looked up
+	if uid < 0 || gid < 0 {
+		info, err := c.Stat(dst)
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*sftp.FileStat)
+		if !ok {
+			return fmt.Errorf("the server didn't report the owner of %s", dst)
+		}
+		if uid < 0 {
+			uid = int(stat.UID)
+		}
+		if gid < 0 {
+			gid = int(stat.GID)
+		}
+	}
+	return c.Chown(dst, uid, gid)
+}
+
+// chownCommand runs chown on the server
+func chownCommand(client *ssh.Client, dst, spec string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(fmt.Sprintf("chown %s %s", shellQuote(spec), shellQuote(dst)))
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
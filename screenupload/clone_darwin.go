@@ -0,0 +1,43 @@
+package screenupload
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as an APFS clone of src, which shares the data
+// blocks until one of them is changed. Clones only work within a volume,
+// it isn't tried if dst would be on another device. It also fails on other
+// file systems and if dst exists.
+func cloneFile(src, dst string) error {
+	same, err := sameDevice(src, filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	if !same {
+		return errors.New("can't clone across volumes")
+	}
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
+
+// sameDevice reports whether two paths are on the same device
+func sameDevice(a, b string) (bool, error) {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	sa, ok := ia.Sys().(*syscall.Stat_t)
+	sb, ok2 := ib.Sys().(*syscall.Stat_t)
+	if !ok || !ok2 {
+		return false, errors.New("no device information")
+	}
+	return sa.Dev == sb.Dev, nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin
+// +build !darwin
+
+package screenupload
+
+import "errors"
+
+// cloneFile is only supported on macOS
+func cloneFile(src, dst string) error {
+	return errors.New("cloning files is not supported on this OS")
+}
@@ -0,0 +1,27 @@
+package screenupload
+
+// Color enables colored log output, the command enables it if stderr is a
+// terminal
+var Color bool
+
+// ANSI escape codes of the colors used in log output
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+)
+
+// colorize wraps s in the color if colored output is enabled
+func colorize(color, s string) string {
+	if !Color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// Failure formats an error for the log, it is red if colored output is
+// enabled
+func Failure(err error) string {
+	return colorize(colorRed, err.Error())
+}
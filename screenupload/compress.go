@@ -0,0 +1,65 @@
+package screenupload
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// compressGzip gzips a file into a temporary file and returns it with a .gz
+// suffix. Images are returned unchanged.
+func compressGzip(cfg Config, f File) (File, error) {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return File{}, err
+	}
+	defer r.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return File{}, err
+	}
+	if strings.HasPrefix(http.DetectContentType(head[:n]), "image/") {
+		return f, nil
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return File{}, err
+	}
+
+	tmp, err := createTemp(cfg, "screenupload-*.gz")
+	if err != nil {
+		return File{}, err
+	}
+	w := gzip.NewWriter(tmp)
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		removeTemp(tmp.Name())
+		return File{}, err
+	}
+
+	before, after := fileSize(f.Path), fileSize(tmp.Name())
+	ratio := 0.0
+	if before > 0 {
+		ratio = float64(after) / float64(before) * 100
+	}
+	log.Printf("compressed %s, %s -> %s (%.0f%%)", f.Name, formatSize(before), formatSize(after), ratio)
+
+	compressed := f
+	compressed.Path = tmp.Name()
+	compressed.Extension = f.Extension + ".gz"
+	compressed.Name = f.Name + ".gz"
+	return compressed, nil
+}
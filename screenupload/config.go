@@ -0,0 +1,668 @@
+package screenupload
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zalando/go-keyring"
+	"gopkg.in/yaml.v3"
+)
+
+// Config contains all the configuration options
+type Config struct {
+	UserName   string   `yaml:"user"`        // Username used on the remote server
+	HostName   string   `yaml:"host"`        // Hostname of the remote server
+	Port       string   `yaml:"port"`        // Port used for SSH on remote server
+	RPath      string   `yaml:"rpath"`       // Remote Path where files should be moved on the remote server
+	RUrl       string   `yaml:"rurl"`        // URL where the image will be accessible on the remote server
+	LPath      string   `yaml:"lpath"`       // Local Path where we are going to watch for new additions
+	Archive    string   `yaml:"archive"`     // Path to directory where files will be archived
+	Filter     string   `yaml:"filter"`      // Regex to filter out files that should be automatically uploaded
+	Exclude    []string `yaml:"exclude"`     // Regexes of files which are never uploaded, even if they match Filter
+	ShowBanner bool     `yaml:"show_banner"` // Log the banner sent by the remote server on connect
+
+	RemoteFileMode os.FileMode `yaml:"remote_file_mode"` // Mode of the uploaded file on the remote server
+	RemotePostCmd  string      `yaml:"remote_post_cmd"`  // Command run on the remote server after an upload, {} is replaced with the remote file path
+
+	KeepAlive time.Duration `yaml:"keepalive"` // Interval of keepalive requests on a persistent connection, disabled if zero
+	Protocol  string        `yaml:"protocol"`  // Transfer protocol of the scp backend, scp, sftp or auto
+
+	Backend        string `yaml:"backend"`          // Backend used for uploads, scp, file, git, webdav or b2
+	FileDest       string `yaml:"file_dest"`        // Directory the file backend copies uploads into
+	GitRepo        string `yaml:"git_repo"`         // Local clone the git backend commits uploads into
+	GitURLTemplate string `yaml:"git_url_template"` // Template of the URL of files uploaded with the git backend
+
+	Sidecar bool `yaml:"sidecar"` // Upload a JSON metadata file next to each upload
+
+	OCR          bool `yaml:"ocr"`           // Extract text from images with tesseract
+	OCRClipboard bool `yaml:"ocr_clipboard"` // Append the extracted text to the URL in the clipboard
+
+	PauseMode string `yaml:"pause_mode"` // What happens to new files while paused, buffer or ignore
+
+	NotifyBatch          bool          `yaml:"notify_batch"`           // Coalesce notifications of uploads within a window
+	NotifyBatchWindow    time.Duration `yaml:"notify_batch_window"`    // Window in which notifications are coalesced
+	NotifyBatchThreshold int           `yaml:"notify_batch_threshold"` // Minimum number of uploads in a window for a summary notification
+
+	MinDimensions string `yaml:"min_dimensions"` // Skip images smaller than WIDTHxHEIGHT
+	MaxDimensions string `yaml:"max_dimensions"` // Skip images larger than WIDTHxHEIGHT
+
+	TempDir string `yaml:"temp_dir"` // Directory for intermediate files
+
+	FollowSymlinks bool `yaml:"follow_symlinks"` // Upload the target of symlinks instead of skipping them
+
+	ConvertTo    string `yaml:"convert_to"`    // Image format uploads are converted to, only webp is supported
+	WebPQuality  int    `yaml:"webp_quality"`  // Quality of lossy WebP conversion from 0 to 100
+	WebPLossless bool   `yaml:"webp_lossless"` // Use lossless WebP conversion
+
+	GIFFilter string        `yaml:"gif_filter"` // Regex of files which are assembled into an animated GIF
+	GIFWindow time.Duration `yaml:"gif_window"` // Time without new frames after which the GIF is assembled
+	GIFDelay  time.Duration `yaml:"gif_delay"`  // Delay between the frames of the GIF
+
+	NotifyOnFailure bool `yaml:"notify_on_failure"` // Send a notification if an upload fails
+
+	ClipboardIncludeName bool   `yaml:"clipboard_include_name"` // Put "name: url" into the clipboard
+	ClipboardTemplate    string `yaml:"clipboard_template"`     // Template of the clipboard content, overrides ClipboardIncludeName
+
+	ArchiveDirMode  os.FileMode `yaml:"archive_dir_mode"`  // Mode of archive directories created by the tool
+	ArchiveFileMode os.FileMode `yaml:"archive_file_mode"` // Mode of archived files, unchanged if zero
+
+	CompressNonImages bool `yaml:"compress_non_images"` // Gzip uploads which are not images
+
+	ArchiveKeep int `yaml:"archive_keep"` // Number of newest files kept in the archive, all if zero
+
+	FollowScreenshotLocation bool `yaml:"follow_screenshot_location"` // Watch the macOS screenshot location instead of a fixed LPath when it changes
+
+	TransferTimeout time.Duration `yaml:"transfer_timeout"` // Maximum duration of a single transfer of the scp backend, unlimited if zero
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`  // Maximum duration of connecting and the SSH handshake, unlimited if zero
+
+	MaxSessions int `yaml:"max_sessions"` // Maximum number of concurrent sessions on the persistent connection
+
+	WebDAVURL         string `yaml:"webdav_url"`          // URL of the WebDAV directory the webdav backend uploads into
+	WebDAVUser        string `yaml:"webdav_user"`         // User of the WebDAV server, no authentication if empty
+	WebDAVPassword    string `yaml:"webdav_password"`     // Password of the WebDAV server
+	WebDAVAuth        string `yaml:"webdav_auth"`         // Authentication scheme of the WebDAV server, basic or digest
+	WebDAVUseLocation bool   `yaml:"webdav_use_location"` // Use the Location header of the upload response as the URL
+
+	AllowEmpty bool `yaml:"allow_empty"` // Upload empty files instead of skipping them
+
+	WaitForNetwork time.Duration `yaml:"wait_for_network"` // Maximum time to wait for the host at startup, disabled if zero
+
+	HostKeyFingerprint string `yaml:"host_key_fingerprint"` // SHA256 fingerprint of the only accepted host key
+
+	NotifyTitle    string `yaml:"notify_title"`    // Template of the notification title
+	NotifySubtitle string `yaml:"notify_subtitle"` // Template of the notification subtitle
+	NotifyMessage  string `yaml:"notify_message"`  // Template of the notification message
+
+	ProcessingDir string `yaml:"processing_dir"` // Directory files are renamed into while they are uploaded
+
+	EditBeforeUpload bool   `yaml:"edit_before_upload"` // Open files in the image editor and wait for it before uploading
+	ImageEditor      string `yaml:"image_editor"`       // Command of the image editor, the path of the file is appended
+
+	B2KeyID          string `yaml:"b2_key_id"`          // ID of the application key of the b2 backend
+	B2ApplicationKey string `yaml:"b2_application_key"` // Application key of the b2 backend
+	B2Bucket         string `yaml:"b2_bucket"`          // Name of the bucket the b2 backend uploads into
+	B2URLTemplate    string `yaml:"b2_url_template"`    // Template of the URL of files uploaded with the b2 backend
+	B2LargeFileSize  int    `yaml:"b2_large_file_size"` // Megabytes from which the b2 backend uploads a file in parts, disabled if zero
+	B2PartSize       int    `yaml:"b2_part_size"`       // Megabytes of a part of a large file uploaded with the b2 backend
+	B2PartUploads    int    `yaml:"b2_part_uploads"`    // Number of parts of a large file uploaded at the same time
+
+	ArchiveNameTemplate string `yaml:"archive_name_template"` // Template of the name of archived files, the remote name is unchanged
+
+	RemoteOwner string `yaml:"remote_owner"` // Owner of uploaded files on the remote server, a name or ID
+	RemoteGroup string `yaml:"remote_group"` // Group of uploaded files on the remote server, a name or ID
+
+	NameCase string `yaml:"name_case"` // Case of remote file names, keep, lower or upper
+
+	IdentityFiles []string `yaml:"identity_files"` // Private keys tried in order before the keys of the ssh agent
+
+	RenameRetries int `yaml:"rename_retries"` // Retries of a rename which failed because the file is busy
+
+	QRCode string `yaml:"qr_code"` // Show a QR code of the URL after an upload, terminal or open
+
+	Dedupe       bool          `yaml:"dedupe"`         // Use the previous URL instead of uploading a file with the same content again
+	DedupeStore  string        `yaml:"dedupe_store"`   // File recording the uploaded files for Dedupe
+	DedupeKeep   int           `yaml:"dedupe_keep"`    // Number of uploads recorded for Dedupe
+	DedupeMaxAge time.Duration `yaml:"dedupe_max_age"` // Age after which an upload is forgotten by Dedupe
+
+	UseSystemSSH bool `yaml:"use_system_ssh"` // Upload with the sftp command of OpenSSH, which uses the SSH config of the user
+
+	SystemSSHFallback bool `yaml:"system_ssh_fallback"` // Upload with OpenSSH if the handshake of the built-in client fails
+
+	ArchiveManifest bool `yaml:"archive_manifest"` // Record the checksums of archived files in manifest.json in the archive
+
+	UploadSchedule    string `yaml:"upload_schedule"`    // Upload new files at an interval or at daily times instead of right away
+	ScheduleClipboard string `yaml:"schedule_clipboard"` // When the URL of a scheduled upload is copied, queue or upload
+
+	CreateArchiveDir bool `yaml:"create_archive_dir"` // Create the archive directory if it does not exist
+
+	Confirm        string        `yaml:"confirm"`         // Ask before copying the URL and removing the file, terminal or dialog
+	ConfirmTimeout time.Duration `yaml:"confirm_timeout"` // Time after which an unanswered confirmation counts as yes
+
+	DiscordWebhook  string `yaml:"discord_webhook"`  // Discord webhook URL uploads are posted to
+	DiscordTemplate string `yaml:"discord_template"` // Template of the Discord message
+
+	MinFreeSpace int `yaml:"min_free_space"` // Free megabytes required on the archive or processing file system
+
+	UserAgent   string   `yaml:"user_agent"`   // User-Agent of the requests of the HTTP backends
+	HTTPHeaders []string `yaml:"http_headers"` // Headers added to the requests of the HTTP backends, as Name: value
+
+	ArchiveRoutes       []string `yaml:"archive_routes"`        // Subdirectories of the archive by extension or content type, as dir=.ext or dir=type/*
+	ArchiveRouteDefault string   `yaml:"archive_route_default"` // Subdirectory of the archive for files matching no route
+
+	QuietHours     string `yaml:"quiet_hours"`      // Daily time ranges without uploads or notifications, e.g. 22:00-07:00
+	QuietHoursMode string `yaml:"quiet_hours_mode"` // What happens during quiet hours, queue or notify
+
+	LogFile       string        `yaml:"log_file"`        // File the log is written to instead of stderr
+	LogMaxSize    int           `yaml:"log_max_size"`    // Megabytes after which the log file is rotated
+	LogMaxBackups int           `yaml:"log_max_backups"` // Number of rotated log files kept, all if zero
+	LogMaxAge     time.Duration `yaml:"log_max_age"`     // Age after which rotated log files are removed, never if zero
+
+	VideoExtensions     []string `yaml:"video_extensions"`      // Extensions of screen recordings
+	VideoRPath          string   `yaml:"video_rpath"`           // Remote path of videos instead of RPath
+	VideoRUrl           string   `yaml:"video_rurl"`            // URL of VideoRPath
+	VideoSkipProcessing bool     `yaml:"video_skip_processing"` // Skip editing, conversion, compression and OCR for videos
+	VideoTranscode      bool     `yaml:"video_transcode"`       // Transcode videos to H.264 MP4 with ffmpeg before uploading
+	VideoTranscodeArgs  []string `yaml:"video_transcode_args"`  // Output arguments of ffmpeg
+
+	Preserve bool `yaml:"preserve"` // Upload files without moving, renaming, archiving or removing them
+
+	CheckRemoteWritable bool `yaml:"check_remote_writable"` // Create and remove a probe file in RPath at startup
+
+	UploadCooldown time.Duration `yaml:"upload_cooldown"` // Minimum time between uploads of the same path, disabled if zero
+
+	SingleInstance bool `yaml:"single_instance"` // Refuse to watch LPath if another instance is watching it
+
+	ProjectDetect  string `yaml:"project_detect"`  // Where the current project is detected for {{.Project}}, env or window
+	ProjectEnv     string `yaml:"project_env"`     // Environment variable containing the current project
+	ProjectPattern string `yaml:"project_pattern"` // Regex extracting the project from the detected value
+
+	HashSalt string `yaml:"hash_salt"` // Secret mixed into the hash of upload names
+
+	Backends       []string `yaml:"backends"`        // Config files of further destinations every upload is copied to
+	PrimaryBackend int      `yaml:"primary_backend"` // Destination whose URL is used, 0 is the main config
+	BackendRetries int      `yaml:"backend_retries"` // Retries of a failed upload to a destination other than the primary
+
+	PoolIdleTTL  time.Duration `yaml:"pool_idle_ttl"`  // Time a connection without keepalive stays open after an upload, disabled if zero
+	PoolMaxConns int           `yaml:"pool_max_conns"` // Maximum number of pooled connections to all servers, unlimited if zero
+
+	ProcessExisting bool `yaml:"process_existing"` // Upload the files in the watch directory when the watcher starts
+	ScanWorkers     int  `yaml:"scan_workers"`     // Files hashed at the same time by the startup scan
+	ScanQueue       int  `yaml:"scan_queue"`       // Files the startup scan prepares ahead of the uploads
+
+	BreakerFailures int           `yaml:"breaker_failures"` // Consecutive failed uploads after which new files are held, disabled if zero
+	BreakerProbe    time.Duration `yaml:"breaker_probe"`    // Interval of probes while the circuit breaker is open
+
+	resolved map[string]string // References of the options resolved from the keyring by key
+}
+
+// option describes a single configuration option, it is used to apply
+// environment variables and to generate the example config file
+type option struct {
+	Key   string                      // Key in the config file
+	Env   string                      // Environment variable overriding the config file
+	Help  string                      // Description of the option
+	Field func(c *Config) interface{} // Pointer to the field in Config
+}
+
+// options lists all the supported configuration options
+var options = []option{
+	{"user", "USER", "Username used on the remote server", func(c *Config) interface{} { return &c.UserName }},
+	{"host", "HOST", "Hostname of the remote server", func(c *Config) interface{} { return &c.HostName }},
+	{"port", "PORT", "Port used for SSH on remote server", func(c *Config) interface{} { return &c.Port }},
+	{"rpath", "RPATH", "Remote Path where files should be moved on the remote server, can contain {{.Project}}", func(c *Config) interface{} { return &c.RPath }},
+	{"rurl", "RURL", "URL where the image will be hosted, can contain {{.Project}}", func(c *Config) interface{} { return &c.RUrl }},
+	{"lpath", "LPATH", "Local Path where we are going to watch for new additions", func(c *Config) interface{} { return &c.LPath }},
+	{"archive", "ARCHIVE", "Path to directory where files will be archived", func(c *Config) interface{} { return &c.Archive }},
+	{"filter", "FILTER", "Regex to filter out files that should be automatically uploaded", func(c *Config) interface{} { return &c.Filter }},
+	{"exclude", "EXCLUDE", "Regexes of files which are never uploaded even if they match the filter, comma separated in the environment", func(c *Config) interface{} { return &c.Exclude }},
+	{"show_banner", "SHOW_BANNER", "Log the banner sent by the remote server on connect", func(c *Config) interface{} { return &c.ShowBanner }},
+	{"remote_file_mode", "REMOTE_FILE_MODE", "Octal file mode of the uploaded file on the remote server", func(c *Config) interface{} { return &c.RemoteFileMode }},
+	{"remote_post_cmd", "REMOTE_POST_CMD", "Command to run on the remote server after each upload, {} is replaced with the remote file path", func(c *Config) interface{} { return &c.RemotePostCmd }},
+	{"keepalive", "KEEPALIVE", "Interval of keepalive requests on a persistent connection, disabled if 0s", func(c *Config) interface{} { return &c.KeepAlive }},
+	{"protocol", "PROTOCOL", "Transfer protocol of the scp backend, scp, sftp or auto to fall back to sftp if the server has no scp", func(c *Config) interface{} { return &c.Protocol }},
+	{"backend", "BACKEND", "Backend used for uploads, scp, file, git, webdav or b2", func(c *Config) interface{} { return &c.Backend }},
+	{"file_dest", "FILE_DEST", "Directory the file backend copies uploads into", func(c *Config) interface{} { return &c.FileDest }},
+	{"git_repo", "GIT_REPO", "Local clone the git backend commits uploads into, RPATH is the directory within it", func(c *Config) interface{} { return &c.GitRepo }},
+	{"git_url_template", "GIT_URL_TEMPLATE", "Template of the URL of files uploaded with the git backend, with {{.Name}} and {{.Path}}", func(c *Config) interface{} { return &c.GitURLTemplate }},
+	{"sidecar", "SIDECAR", "Upload a JSON metadata file next to each upload", func(c *Config) interface{} { return &c.Sidecar }},
+	{"ocr", "OCR", "Extract text from images with tesseract and add it to the sidecar metadata", func(c *Config) interface{} { return &c.OCR }},
+	{"ocr_clipboard", "OCR_CLIPBOARD", "Append the extracted text to the URL in the clipboard", func(c *Config) interface{} { return &c.OCRClipboard }},
+	{"pause_mode", "PAUSE_MODE", "What happens to new files while paused, buffer uploads them on resume, ignore skips them", func(c *Config) interface{} { return &c.PauseMode }},
+	{"notify_batch", "NOTIFY_BATCH", "Send one summary notification for uploads within a window and copy all URLs", func(c *Config) interface{} { return &c.NotifyBatch }},
+	{"notify_batch_window", "NOTIFY_BATCH_WINDOW", "Window in which notifications are coalesced", func(c *Config) interface{} { return &c.NotifyBatchWindow }},
+	{"notify_batch_threshold", "NOTIFY_BATCH_THRESHOLD", "Minimum number of uploads in a window for a summary notification", func(c *Config) interface{} { return &c.NotifyBatchThreshold }},
+	{"min_dimensions", "MIN_DIMENSIONS", "Skip images smaller than WIDTHxHEIGHT, e.g. 64x64", func(c *Config) interface{} { return &c.MinDimensions }},
+	{"max_dimensions", "MAX_DIMENSIONS", "Skip images larger than WIDTHxHEIGHT, e.g. 8000x8000", func(c *Config) interface{} { return &c.MaxDimensions }},
+	{"temp_dir", "TEMP_DIR", "Directory for intermediate files", func(c *Config) interface{} { return &c.TempDir }},
+	{"follow_symlinks", "FOLLOW_SYMLINKS", "Upload a copy of the target of symlinks instead of skipping them", func(c *Config) interface{} { return &c.FollowSymlinks }},
+	{"convert_to", "CONVERT_TO", "Image format uploads are converted to, only webp is supported", func(c *Config) interface{} { return &c.ConvertTo }},
+	{"webp_quality", "WEBP_QUALITY", "Quality of lossy WebP conversion from 0 to 100", func(c *Config) interface{} { return &c.WebPQuality }},
+	{"webp_lossless", "WEBP_LOSSLESS", "Use lossless WebP conversion", func(c *Config) interface{} { return &c.WebPLossless }},
+	{"gif_filter", "GIF_FILTER", "Regex of files which are assembled into an animated GIF instead of being uploaded individually", func(c *Config) interface{} { return &c.GIFFilter }},
+	{"gif_window", "GIF_WINDOW", "Time without new frames after which the GIF is assembled", func(c *Config) interface{} { return &c.GIFWindow }},
+	{"gif_delay", "GIF_DELAY", "Delay between the frames of the GIF", func(c *Config) interface{} { return &c.GIFDelay }},
+	{"notify_on_failure", "NOTIFY_ON_FAILURE", "Send a notification with the file name and error if an upload fails", func(c *Config) interface{} { return &c.NotifyOnFailure }},
+	{"clipboard_include_name", "CLIPBOARD_INCLUDE_NAME", "Put the original file name in front of the URL in the clipboard", func(c *Config) interface{} { return &c.ClipboardIncludeName }},
+	{"clipboard_template", "CLIPBOARD_TEMPLATE", "Template of the clipboard content with {{.Name}}, {{.URL}} and {{.Size}}, e.g. [{{.Name}}]({{.URL}})", func(c *Config) interface{} { return &c.ClipboardTemplate }},
+	{"archive_dir_mode", "ARCHIVE_DIR_MODE", "Octal mode of archive directories created by the tool", func(c *Config) interface{} { return &c.ArchiveDirMode }},
+	{"archive_file_mode", "ARCHIVE_FILE_MODE", "Octal mode of archived files, 0 keeps their mode", func(c *Config) interface{} { return &c.ArchiveFileMode }},
+	{"compress_non_images", "COMPRESS_NON_IMAGES", "Gzip uploads which are not images, their name gets a .gz suffix", func(c *Config) interface{} { return &c.CompressNonImages }},
+	{"archive_keep", "ARCHIVE_KEEP", "Number of newest files kept in the archive, older ones are removed, 0 keeps all", func(c *Config) interface{} { return &c.ArchiveKeep }},
+	{"follow_screenshot_location", "FOLLOW_SCREENSHOT_LOCATION", "Switch to the new directory when the macOS screenshot location changes", func(c *Config) interface{} { return &c.FollowScreenshotLocation }},
+	{"transfer_timeout", "TRANSFER_TIMEOUT", "Maximum duration of a single transfer of the scp backend, a stalled transfer is aborted and retried once, disabled if 0s", func(c *Config) interface{} { return &c.TransferTimeout }},
+	{"connect_timeout", "CONNECT_TIMEOUT", "Maximum duration of connecting to the SSH server including the handshake, disabled if 0s", func(c *Config) interface{} { return &c.ConnectTimeout }},
+	{"max_sessions", "MAX_SESSIONS", "Maximum number of concurrent sessions on the persistent connection, further uploads wait, 0 for no limit", func(c *Config) interface{} { return &c.MaxSessions }},
+	{"webdav_url", "WEBDAV_URL", "URL of the WebDAV directory the webdav backend uploads into, RPATH is relative to it", func(c *Config) interface{} { return &c.WebDAVURL }},
+	{"webdav_user", "WEBDAV_USER", "User of the WebDAV server, see WEBDAV_AUTH", func(c *Config) interface{} { return &c.WebDAVUser }},
+	{"webdav_password", "WEBDAV_PASSWORD", "Password of the WebDAV server, e.g. keyring:screenupload/webdav", func(c *Config) interface{} { return &c.WebDAVPassword }},
+	{"webdav_auth", "WEBDAV_AUTH", "Authentication of WEBDAV_USER on the WebDAV server, basic or digest", func(c *Config) interface{} { return &c.WebDAVAuth }},
+	{"webdav_use_location", "WEBDAV_USE_LOCATION", "Use the Location header the WebDAV server returns for an upload as its URL instead of RURL", func(c *Config) interface{} { return &c.WebDAVUseLocation }},
+	{"allow_empty", "ALLOW_EMPTY", "Upload empty files instead of skipping them", func(c *Config) interface{} { return &c.AllowEmpty }},
+	{"wait_for_network", "WAIT_FOR_NETWORK", "Maximum time to wait at startup until the host accepts connections, disabled if 0s", func(c *Config) interface{} { return &c.WaitForNetwork }},
+	{"host_key_fingerprint", "HOST_KEY_FINGERPRINT", "SHA256 fingerprint of the only host key accepted from the server, e.g. SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8", func(c *Config) interface{} { return &c.HostKeyFingerprint }},
+	{"notify_title", "NOTIFY_TITLE", "Template of the notification title with {{.Name}}, {{.URL}}, {{.Size}}, {{.Duration}} and {{.Count}}", func(c *Config) interface{} { return &c.NotifyTitle }},
+	{"notify_subtitle", "NOTIFY_SUBTITLE", "Template of the notification subtitle, {{size .Size}} formats the size", func(c *Config) interface{} { return &c.NotifySubtitle }},
+	{"notify_message", "NOTIFY_MESSAGE", "Template of the notification message", func(c *Config) interface{} { return &c.NotifyMessage }},
+	{"processing_dir", "PROCESSING_DIR", "Directory files are renamed into while they are uploaded if ARCHIVE is unset, .screenupload in LPATH if empty", func(c *Config) interface{} { return &c.ProcessingDir }},
+	{"edit_before_upload", "EDIT_BEFORE_UPLOAD", "Open files in the image editor and upload them once it is closed", func(c *Config) interface{} { return &c.EditBeforeUpload }},
+	{"image_editor", "IMAGE_EDITOR", "Command of the image editor, the file path is appended, Preview on macOS if empty", func(c *Config) interface{} { return &c.ImageEditor }},
+	{"b2_key_id", "B2_KEY_ID", "ID of the application key of the b2 backend", func(c *Config) interface{} { return &c.B2KeyID }},
+	{"b2_application_key", "B2_APPLICATION_KEY", "Application key of the b2 backend, e.g. keyring:screenupload/b2", func(c *Config) interface{} { return &c.B2ApplicationKey }},
+	{"b2_bucket", "B2_BUCKET", "Name of the bucket the b2 backend uploads into, RPATH is the directory within it", func(c *Config) interface{} { return &c.B2Bucket }},
+	{"b2_url_template", "B2_URL_TEMPLATE", "Template of the URL of files uploaded with the b2 backend, with {{.Name}}, {{.Path}}, {{.Bucket}} and {{.DownloadURL}}", func(c *Config) interface{} { return &c.B2URLTemplate }},
+	{"b2_large_file_size", "B2_LARGE_FILE_SIZE", "Megabytes from which the b2 backend uploads a file in parts, 0 to always upload it at once", func(c *Config) interface{} { return &c.B2LargeFileSize }},
+	{"b2_part_size", "B2_PART_SIZE", "Megabytes of a part of a large file uploaded with the b2 backend, at least 5", func(c *Config) interface{} { return &c.B2PartSize }},
+	{"b2_part_uploads", "B2_PART_UPLOADS", "Number of parts of a large file the b2 backend uploads at the same time", func(c *Config) interface{} { return &c.B2PartUploads }},
+	{"archive_name_template", "ARCHIVE_NAME_TEMPLATE", "Template of the name of archived files with {{.OriginalName}}, {{.Hash}}, {{.Extension}} and {{.Time}}, e.g. {{.OriginalName}}", func(c *Config) interface{} { return &c.ArchiveNameTemplate }},
+	{"remote_owner", "REMOTE_OWNER", "Owner of uploaded files on the remote server, a name or numeric ID", func(c *Config) interface{} { return &c.RemoteOwner }},
+	{"remote_group", "REMOTE_GROUP", "Group of uploaded files on the remote server, a name or numeric ID", func(c *Config) interface{} { return &c.RemoteGroup }},
+	{"name_case", "NAME_CASE", "Case of remote file names, keep, lower or upper", func(c *Config) interface{} { return &c.NameCase }},
+	{"identity_files", "IDENTITY_FILES", "Comma separated private keys tried in order before the keys of the ssh agent", func(c *Config) interface{} { return &c.IdentityFiles }},
+	{"rename_retries", "RENAME_RETRIES", "How often a rename which failed because the file is busy is retried", func(c *Config) interface{} { return &c.RenameRetries }},
+	{"qr_code", "QR_CODE", "Show a QR code of the URL after an upload, terminal prints it, open opens it in the image viewer", func(c *Config) interface{} { return &c.QRCode }},
+	{"dedupe", "DEDUPE", "Use the previous URL instead of uploading a file with the same content again, also across restarts", func(c *Config) interface{} { return &c.Dedupe }},
+	{"dedupe_store", "DEDUPE_STORE", "File recording the uploaded files for dedupe, defaults to the user cache directory", func(c *Config) interface{} { return &c.DedupeStore }},
+	{"dedupe_keep", "DEDUPE_KEEP", "Number of uploads recorded for dedupe, older ones are forgotten", func(c *Config) interface{} { return &c.DedupeKeep }},
+	{"dedupe_max_age", "DEDUPE_MAX_AGE", "Age after which an upload is forgotten by dedupe, 0 keeps them", func(c *Config) interface{} { return &c.DedupeMaxAge }},
+	{"use_system_ssh", "USE_SYSTEM_SSH", "Upload with the sftp command of OpenSSH instead of the built-in client, it uses your SSH config and ControlMaster connections", func(c *Config) interface{} { return &c.UseSystemSSH }},
+	{"system_ssh_fallback", "SYSTEM_SSH_FALLBACK", "Upload with the sftp command of OpenSSH from then on if the handshake of the built-in client fails", func(c *Config) interface{} { return &c.SystemSSHFallback }},
+	{"archive_manifest", "ARCHIVE_MANIFEST", "Record the checksums of archived files in manifest.json in the archive, check them with -check-archive", func(c *Config) interface{} { return &c.ArchiveManifest }},
+	{"upload_schedule", "UPLOAD_SCHEDULE", "Upload new files at an interval like 30m or at daily times like 12:00,18:00 instead of right away", func(c *Config) interface{} { return &c.UploadSchedule }},
+	{"schedule_clipboard", "SCHEDULE_CLIPBOARD", "When the URL of a scheduled upload is copied, queue copies it right away, upload after the upload", func(c *Config) interface{} { return &c.ScheduleClipboard }},
+	{"create_archive_dir", "CREATE_ARCHIVE_DIR", "Create the archive directory if it does not exist, otherwise uploads fail until it exists", func(c *Config) interface{} { return &c.CreateArchiveDir }},
+	{"confirm", "CONFIRM", "Ask before copying the URL and removing the local file, terminal asks on stdin, dialog shows a dialog on macOS", func(c *Config) interface{} { return &c.Confirm }},
+	{"confirm_timeout", "CONFIRM_TIMEOUT", "Time after which an unanswered confirmation counts as yes", func(c *Config) interface{} { return &c.ConfirmTimeout }},
+	{"discord_webhook", "DISCORD_WEBHOOK", "Discord webhook URL uploads are posted to with an embedded preview, e.g. keyring:screenupload/discord", func(c *Config) interface{} { return &c.DiscordWebhook }},
+	{"discord_template", "DISCORD_TEMPLATE", "Template of the Discord message, with .Name, .URL and .Size like CLIPBOARD_TEMPLATE", func(c *Config) interface{} { return &c.DiscordTemplate }},
+	{"min_free_space", "MIN_FREE_SPACE", "Free megabytes required on the file system of ARCHIVE or PROCESSING_DIR, files are left in place if there is less", func(c *Config) interface{} { return &c.MinFreeSpace }},
+	{"user_agent", "USER_AGENT", "User-Agent of the requests of the webdav and b2 backends", func(c *Config) interface{} { return &c.UserAgent }},
+	{"http_headers", "HTTP_HEADERS", "Comma separated headers added to the requests of the webdav and b2 backends, as Name: value", func(c *Config) interface{} { return &c.HTTPHeaders }},
+	{"archive_routes", "ARCHIVE_ROUTES", "Comma separated subdirectories of the archive by extension or content type, e.g. images=image/*,videos=video/*,docs=.pdf", func(c *Config) interface{} { return &c.ArchiveRoutes }},
+	{"archive_route_default", "ARCHIVE_ROUTE_DEFAULT", "Subdirectory of the archive for files matching none of ARCHIVE_ROUTES, the archive itself if empty", func(c *Config) interface{} { return &c.ArchiveRouteDefault }},
+	{"quiet_hours", "QUIET_HOURS", "Comma separated daily time ranges without uploads or notifications, e.g. 22:00-07:00,12:00-13:00", func(c *Config) interface{} { return &c.QuietHours }},
+	{"quiet_hours_mode", "QUIET_HOURS_MODE", "What happens to new files during QUIET_HOURS, queue holds them until the quiet hours end, notify uploads them without notifications", func(c *Config) interface{} { return &c.QuietHoursMode }},
+	{"log_file", "LOG_FILE", "File the log is written to instead of stderr, it is rotated by size", func(c *Config) interface{} { return &c.LogFile }},
+	{"log_max_size", "LOG_MAX_SIZE", "Megabytes after which LOG_FILE is rotated", func(c *Config) interface{} { return &c.LogMaxSize }},
+	{"log_max_backups", "LOG_MAX_BACKUPS", "Number of rotated log files kept, 0 keeps all", func(c *Config) interface{} { return &c.LogMaxBackups }},
+	{"log_max_age", "LOG_MAX_AGE", "Age after which rotated log files are removed, e.g. 720h, 0s keeps them", func(c *Config) interface{} { return &c.LogMaxAge }},
+	{"video_extensions", "VIDEO_EXTENSIONS", "Comma separated extensions of screen recordings which are handled as videos", func(c *Config) interface{} { return &c.VideoExtensions }},
+	{"video_rpath", "VIDEO_RPATH", "Remote path of videos instead of RPATH", func(c *Config) interface{} { return &c.VideoRPath }},
+	{"video_rurl", "VIDEO_RURL", "URL of VIDEO_RPATH, required with it", func(c *Config) interface{} { return &c.VideoRUrl }},
+	{"video_skip_processing", "VIDEO_SKIP_PROCESSING", "Upload videos as they are, without EDIT_BEFORE_UPLOAD, CONVERT_TO, COMPRESS_NON_IMAGES and OCR", func(c *Config) interface{} { return &c.VideoSkipProcessing }},
+	{"video_transcode", "VIDEO_TRANSCODE", "Transcode videos which aren't MP4 to H.264 MP4 with ffmpeg before uploading", func(c *Config) interface{} { return &c.VideoTranscode }},
+	{"video_transcode_args", "VIDEO_TRANSCODE_ARGS", "Comma separated output arguments of ffmpeg for VIDEO_TRANSCODE", func(c *Config) interface{} { return &c.VideoTranscodeArgs }},
+	{"preserve", "PRESERVE", "Upload files under a generated remote name without moving, renaming, archiving or removing the local file", func(c *Config) interface{} { return &c.Preserve }},
+	{"check_remote_writable", "CHECK_REMOTE_WRITABLE", "Create and remove a probe file in RPATH at startup and refuse to start if the SSH user can't write there", func(c *Config) interface{} { return &c.CheckRemoteWritable }},
+	{"upload_cooldown", "UPLOAD_COOLDOWN", "Minimum time between uploads of the same path like 30s, files created again at the path within it are skipped", func(c *Config) interface{} { return &c.UploadCooldown }},
+	{"single_instance", "SINGLE_INSTANCE", "Refuse to start watching LPATH if another instance is already watching it", func(c *Config) interface{} { return &c.SingleInstance }},
+	{"project_detect", "PROJECT_DETECT", "Detect the current project for {{.Project}} in RPATH and RURL from an environment variable (env) or the title of the frontmost window on macOS (window)", func(c *Config) interface{} { return &c.ProjectDetect }},
+	{"project_env", "PROJECT_ENV", "Environment variable containing the current project with PROJECT_DETECT=env", func(c *Config) interface{} { return &c.ProjectEnv }},
+	{"project_pattern", "PROJECT_PATTERN", "Regex extracting the project from the detected value, its first group if it has one", func(c *Config) interface{} { return &c.ProjectPattern }},
+	{"hash_salt", "HASH_SALT", "Secret mixed into the hash upload names are generated from, keep it stable and secret", func(c *Config) interface{} { return &c.HashSalt }},
+	{"backends", "BACKENDS", "Comma separated config files of further destinations every file is uploaded to as well, each sets the options which differ from the main config", func(c *Config) interface{} { return &c.Backends }},
+	{"primary_backend", "PRIMARY_BACKEND", "Destination whose URL is copied with BACKENDS, 0 is the main config and 1 the first file of BACKENDS", func(c *Config) interface{} { return &c.PrimaryBackend }},
+	{"backend_retries", "BACKEND_RETRIES", "Retries of a failed upload to a destination other than the primary with BACKENDS", func(c *Config) interface{} { return &c.BackendRetries }},
+	{"pool_idle_ttl", "POOL_IDLE_TTL", "Time an SSH connection stays open after an upload without KEEPALIVE to be reused by the next one, disabled if 0s", func(c *Config) interface{} { return &c.PoolIdleTTL }},
+	{"pool_max_conns", "POOL_MAX_CONNS", "Maximum number of SSH connections kept open by POOL_IDLE_TTL to all servers together, 0 for no limit", func(c *Config) interface{} { return &c.PoolMaxConns }},
+	{"process_existing", "PROCESS_EXISTING", "Upload the files which are in the watch directory already when the watcher starts", func(c *Config) interface{} { return &c.ProcessExisting }},
+	{"scan_workers", "SCAN_WORKERS", "Number of files hashed at the same time by the startup scan of PROCESS_EXISTING with DEDUPE or ARCHIVE_MANIFEST", func(c *Config) interface{} { return &c.ScanWorkers }},
+	{"scan_queue", "SCAN_QUEUE", "Number of files the startup scan of PROCESS_EXISTING hashes ahead of the uploads, it waits while that many are queued", func(c *Config) interface{} { return &c.ScanQueue }},
+	{"breaker_failures", "BREAKER_FAILURES", "Consecutive failed uploads after which new files are held until a probe of the server succeeds, 0 to disable", func(c *Config) interface{} { return &c.BreakerFailures }},
+	{"breaker_probe", "BREAKER_PROBE", "Interval of the probes of the server while uploads are stopped by BREAKER_FAILURES", func(c *Config) interface{} { return &c.BreakerProbe }},
+}
+
+// DefaultConfig returns the configuration used if nothing else is set
+func DefaultConfig() Config {
+	return Config{
+		Port:              "22",
+		Filter:            `^Screen.Shot.[0-9-]*.\w*.[0-9.]*.png`,
+		RemoteFileMode:    0644,
+		ArchiveDirMode:    0755,
+		MaxSessions:       10,
+		NotifyTitle:       defaultNotifyTitle,
+		NotifySubtitle:    defaultNotifySubtitle,
+		NotifyMessage:     defaultNotifyMessage,
+		Backend:           "scp",
+		WebDAVAuth:        "basic",
+		Protocol:          "auto",
+		PauseMode:         "buffer",
+		NameCase:          "keep",
+		RenameRetries:     3,
+		CreateArchiveDir:  true,
+		ConfirmTimeout:    30 * time.Second,
+		ConnectTimeout:    30 * time.Second,
+		DiscordTemplate:   "{{.Name}}",
+		ScheduleClipboard: "queue",
+		QuietHoursMode:    "queue",
+		LogMaxSize:        10,
+		LogMaxBackups:     3,
+		DedupeKeep:        1000,
+		DedupeMaxAge:      30 * 24 * time.Hour,
+		TempDir:           os.TempDir(),
+		WebPQuality:       80,
+
+		NotifyOnFailure: true,
+
+		NotifyBatchWindow:    2 * time.Second,
+		NotifyBatchThreshold: 2,
+
+		GIFWindow: 5 * time.Second,
+		GIFDelay:  500 * time.Millisecond,
+
+		VideoExtensions:     []string{".mov", ".mp4", ".m4v", ".webm", ".mkv"},
+		VideoSkipProcessing: true,
+		VideoTranscodeArgs:  []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p", "-c:a", "aac", "-movflags", "+faststart"},
+
+		SingleInstance: true,
+
+		ProjectEnv: "SCREENUPLOAD_PROJECT",
+
+		BackendRetries:  3,
+		B2LargeFileSize: 200,
+		B2PartSize:      100,
+		B2PartUploads:   4,
+		PoolIdleTTL:     time.Minute,
+		PoolMaxConns:    4,
+	}
+}
+
+// SystemConfigPath is the config file with system wide defaults
+const SystemConfigPath = "/etc/screenupload/config.yaml"
+
+// DefaultConfigPath returns the path of the user config file, it can be set
+// with the CONFIG environment variable
+func DefaultConfigPath() string {
+	if p := os.Getenv("CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "config.yaml"
+	}
+	return filepath.Join(dir, "screenupload", "config.yaml")
+}
+
+// LoadConfig merges the layers of the configuration: the defaults, the
+// config files in the given order, the environment variables and the
+// options set on the command line. Every layer only overrides what it sets.
+func LoadConfig(paths []string, flags map[string]string) (Config, error) {
+	c := DefaultConfig()
+
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Config{}, err
+		}
+		err = yaml.Unmarshal(b, &c)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+		debugf("loaded config file %s", path)
+	}
+
+	for _, o := range options {
+		v := os.Getenv(o.Env)
+		if v == "" {
+			continue
+		}
+		err := setValue(o.Field(&c), v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %v", o.Env, err)
+		}
+	}
+
+	for _, o := range options {
+		v, ok := flags[o.Key]
+		if !ok {
+			continue
+		}
+		err := setValue(o.Field(&c), v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid -%s: %v", o.Key, err)
+		}
+	}
+
+	if c.LPath == "" {
+		c.LPath = defaultScreenshotDir()
+		debugf("LPATH is unset, watching %s", c.LPath)
+	}
+
+	if Debug {
+		debugf("effective config:\n%s", FormatConfig(c))
+	}
+
+	err := resolveSecrets(&c)
+	if err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// resolveSecrets replaces the secrets referenced as keyring:service/account
+// with their values from the OS keychain
+func resolveSecrets(c *Config) error {
+	for _, o := range options {
+		f, ok := o.Field(c).(*string)
+		if !ok || !strings.HasPrefix(*f, KeyringPrefix) {
+			continue
+		}
+		ref := *f
+		secret, err := getSecret(ref)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", o.Key, err)
+		}
+		*f = secret
+		if c.resolved == nil {
+			c.resolved = make(map[string]string)
+		}
+		c.resolved[o.Key] = ref
+	}
+	return nil
+}
+
+// KeyringPrefix marks a value which is stored in the OS keychain
+const KeyringPrefix = "keyring:"
+
+// splitSecretRef splits a keyring:service/account reference
+func splitSecretRef(ref string) (service, account string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(ref, KeyringPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid secret reference %q, expected keyring:service/account", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// getSecret reads a secret from the OS keychain
+func getSecret(ref string) (string, error) {
+	service, account, err := splitSecretRef(ref)
+	if err != nil {
+		return "", err
+	}
+	return keyring.Get(service, account)
+}
+
+// SetSecret stores a secret in the OS keychain
+func SetSecret(ref, secret string) error {
+	service, account, err := splitSecretRef(ref)
+	if err != nil {
+		return err
+	}
+	return keyring.Set(service, account, secret)
+}
+
+// setValue parses a string into the field pointed to by field
+func setValue(field interface{}, v string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = v
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*f = b
+	case *os.FileMode:
+		m, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return err
+		}
+		*f = os.FileMode(m)
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*f = d
+	case *[]string:
+		*f = strings.Split(v, ",")
+	case *int:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*f = i
+	default:
+		return fmt.Errorf("unsupported option type %T", field)
+	}
+	return nil
+}
+
+// optionFlag is a command line flag setting an option, the value is
+// applied on top of the other configuration layers
+type optionFlag struct {
+	field interface{}
+	value string
+}
+
+func (f *optionFlag) String() string { return f.value }
+
+func (f *optionFlag) Set(v string) error {
+	f.value = v
+	return nil
+}
+
+// IsBoolFlag allows boolean options to be set without a value
+func (f *optionFlag) IsBoolFlag() bool {
+	_, ok := f.field.(*bool)
+	return ok
+}
+
+// RegisterOptionFlags adds a flag for every option to fs and returns a
+// function which collects the values of the flags that were set
+func RegisterOptionFlags(fs *flag.FlagSet) func() map[string]string {
+	var def Config
+	flags := make(map[string]*optionFlag)
+	for _, o := range options {
+		f := &optionFlag{field: o.Field(&def)}
+		flags[o.Key] = f
+		fs.Var(f, o.Key, o.Help)
+	}
+	return func() map[string]string {
+		set := make(map[string]string)
+		fs.Visit(func(fl *flag.Flag) {
+			if f, ok := flags[fl.Name]; ok {
+				set[fl.Name] = f.value
+			}
+		})
+		return set
+	}
+}
+
+// formatValue formats the field pointed to by field for the config file
+func formatValue(field interface{}) string {
+	switch f := field.(type) {
+	case *os.FileMode:
+		return fmt.Sprintf("%#o", uint32(*f))
+	case *time.Duration:
+		return f.String()
+	case *[]string:
+		// lists are written in flow style to fit on the line of their key
+		n := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, v := range *f {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+		}
+		field = n
+	}
+	b, err := yaml.Marshal(field)
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// secretOptions are the keys of options which contain secrets
+var secretOptions = map[string]bool{
+	"webdav_password":    true,
+	"b2_application_key": true,
+	"discord_webhook":    true,
+	"http_headers":       true,
+	"hash_salt":          true,
+}
+
+// redacted replaces secrets in the formatted config
+const redacted = "<redacted>"
+
+// FormatConfig formats all options of a config as YAML, secrets and values
+// resolved from the keyring are redacted
+func FormatConfig(c Config) string {
+	var buf bytes.Buffer
+	for _, o := range options {
+		v := formatValue(o.Field(&c))
+		if v != `""` && v != "[]" && (secretOptions[o.Key] || c.resolved[o.Key] != "") {
+			v = redacted
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", o.Key, v)
+	}
+	return buf.String()
+}
+
+// WriteExampleConfig writes a commented config file containing all the
+// options and their default values
+func WriteExampleConfig(path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# go-screenupload configuration\n")
+	buf.WriteString("# Every option can be overridden by the environment variable noted above it.\n")
+	def := DefaultConfig()
+	for _, o := range options {
+		fmt.Fprintf(&buf, "\n# %s (%s)\n%s: %s\n", o.Help, o.Env, o.Key, formatValue(o.Field(&def)))
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0600)
+}
@@ -0,0 +1,21 @@
+package screenupload
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatConfigRedactsSecrets(t *testing.T) {
+	c := DefaultConfig()
+	c.HashSalt = "pepper"
+	c.WebDAVPassword = "hunter2"
+	out := FormatConfig(c)
+	for _, secret := range []string{"pepper", "hunter2"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("formatted config contains %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "hash_salt: "+redacted) {
+		t.Errorf("hash_salt isn't redacted:\n%s", out)
+	}
+}
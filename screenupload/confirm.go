@@ -0,0 +1,102 @@
+package screenupload
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// confirmMu serializes questions of concurrent uploads
+	confirmMu sync.Mutex
+
+	// answers are the lines read from stdin, one reader is shared by all
+	// questions so no answer is lost to a timed out question
+	answers     chan string
+	answersOnce sync.Once
+)
+
+// checkConfirm checks that Confirm can ask the user
+func checkConfirm(cfg Config) error {
+	switch cfg.Confirm {
+	case "":
+	case "terminal":
+		info, err := os.Stdin.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return errors.New("CONFIRM=terminal requires stdin to be a terminal")
+		}
+	case "dialog":
+		if runtime.GOOS != "darwin" {
+			return errors.New("CONFIRM=dialog is only supported on macOS")
+		}
+	default:
+		return fmt.Errorf("unknown CONFIRM %q", cfg.Confirm)
+	}
+	return nil
+}
+
+// confirm shows the URL of an upload and asks whether it should be copied,
+// the answer is yes if the user doesn't answer within ConfirmTimeout
+func confirm(cfg Config, name, url string) (bool, error) {
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+
+	switch cfg.Confirm {
+	case "terminal":
+		return confirmTerminal(cfg, name, url), nil
+	case "dialog":
+		return confirmDialog(cfg, name, url)
+	}
+	return true, nil
+}
+
+// confirmTerminal asks on stderr and reads the answer from stdin
+func confirmTerminal(cfg Config, name, url string) bool {
+	answersOnce.Do(func() {
+		answers = make(chan string)
+		go func() {
+			s := bufio.NewScanner(os.Stdin)
+			for s.Scan() {
+				answers <- s.Text()
+			}
+			close(answers)
+		}()
+	})
+
+	fmt.Fprintf(os.Stderr, "%s uploaded to %s\ncopy the URL and remove the file? [Y/n] ", name, url)
+	timeout := time.NewTimer(cfg.ConfirmTimeout)
+	defer timeout.Stop()
+	select {
+	case a, ok := <-answers:
+		if !ok {
+			return true
+		}
+		switch strings.ToLower(strings.TrimSpace(a)) {
+		case "n", "no":
+			return false
+		}
+		return true
+	case <-timeout.C:
+		fmt.Fprintln(os.Stderr, "no answer, copying")
+		return true
+	}
+}
+
+// confirmDialog asks with a dialog which is dismissed after ConfirmTimeout
+func confirmDialog(cfg Config, name, url string) (bool, error) {
+	script := fmt.Sprintf(`display dialog %q with title "Screen Upload" buttons {"Don't Copy", "Copy"} default button "Copy" giving up after %d`,
+		fmt.Sprintf("%s uploaded to\n%s\n\nCopy the URL and remove the file?", name, url),
+		int(cfg.ConfirmTimeout.Seconds()))
+	out, err := exec.Command("osascript", "-e", script).CombinedOutput()
+	if err != nil {
+		return true, fmt.Errorf("%v: %s", err, out)
+	}
+	// gave up:true is returned after the timeout
+	return !strings.Contains(string(out), "button returned:Don't Copy"), nil
+}
@@ -0,0 +1,210 @@
+package screenupload
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxBackoff is the upper bound of the delay between reconnect attempts
+const maxBackoff = 2 * time.Minute
+
+// clientWait is how long an upload waits for the persistent connection to
+// come back before it fails
+const clientWait = 10 * time.Second
+
+// errDisconnected is returned if the persistent connection didn't come back
+// in time
+var errDisconnected = errors.New("not connected to the server")
+
+// errConnectionClosed is returned by a closed connection
+var errConnectionClosed = errors.New("connection closed")
+
+// alwaysConnected is returned by Connected of uploaders without a
+// persistent connection
+var alwaysConnected = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// connection is a persistent SSH connection which is kept alive and
+// reconnected with exponential backoff when the server goes away
+type connection struct {
+	cfg    Config
+	mu     sync.Mutex
+	client *ssh.Client
+	up     chan struct{} // closed while client is connected
+
+	sessions  chan struct{} // limits concurrent sessions to MaxSessions
+	done      chan struct{} // closed by Close to stop the keepalive loop
+	closeOnce sync.Once
+}
+
+// newConnection connects to the remote server in the background and starts
+// the keepalive loop, it runs until Close is called
+func newConnection(cfg Config) *connection {
+	c := &connection{cfg: cfg, up: make(chan struct{}), done: make(chan struct{})}
+	if cfg.MaxSessions > 0 {
+		c.sessions = make(chan struct{}, cfg.MaxSessions)
+	}
+	go func() {
+		if c.reconnect() {
+			c.keepalive()
+		}
+	}()
+	return c
+}
+
+// Client returns the current client, waiting until a connection is
+// available or ctx is done
+func (c *connection) Client(ctx context.Context) (*ssh.Client, error) {
+	for {
+		c.mu.Lock()
+		client, up := c.client, c.up
+		c.mu.Unlock()
+		if client != nil {
+			return client, nil
+		}
+		select {
+		case <-up:
+		case <-c.done:
+			return nil, errConnectionClosed
+		case <-ctx.Done():
+			return nil, errDisconnected
+		}
+	}
+}
+
+// Connected returns a channel which is closed while the connection is up
+func (c *connection) Connected() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.up
+}
+
+// Acquire waits until another session can be opened without exceeding
+// MaxSessions, it has to be followed by Release
+func (c *connection) Acquire() {
+	if c.sessions != nil {
+		c.sessions <- struct{}{}
+	}
+}
+
+// Release frees a session acquired with Acquire
+func (c *connection) Release() {
+	if c.sessions != nil {
+		<-c.sessions
+	}
+}
+
+// MarkDead drops the given client so the keepalive loop reconnects
+func (c *connection) MarkDead(client *ssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != client || client == nil {
+		return
+	}
+	log.Println("connection: marked dead")
+	c.client.Close()
+	c.client = nil
+	c.up = make(chan struct{})
+}
+
+// Close stops the keepalive loop and closes the connection, uploads
+// waiting for it fail
+func (c *connection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.client != nil {
+			c.client.Close()
+			c.client = nil
+		}
+	})
+	return nil
+}
+
+// keepalive sends keepalive requests and reconnects if one of them fails
+// or isn't answered before the next one is due
+func (c *connection) keepalive() {
+	for {
+		select {
+		case <-time.After(c.cfg.KeepAlive):
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		client := c.client
+		c.mu.Unlock()
+
+		if client != nil {
+			err := sendKeepalive(client, c.cfg.KeepAlive)
+			if err == nil {
+				continue
+			}
+			log.Println("connection: keepalive failed:", err)
+			c.MarkDead(client)
+		}
+		if !c.reconnect() {
+			return
+		}
+	}
+}
+
+// sendKeepalive sends a keepalive request and waits at most timeout for the
+// reply, a server which stopped responding doesn't block the caller
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errors.New("no reply within " + timeout.String())
+	}
+}
+
+// reconnect dials the remote server until it succeeds, doubling the delay
+// between attempts up to maxBackoff. It reports false if the connection
+// was closed in the meantime.
+func (c *connection) reconnect() bool {
+	backoff := time.Second
+	for {
+		log.Println("connection: connecting to", c.cfg.HostName)
+		client, err := dial(c.cfg)
+		if err == nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			select {
+			case <-c.done:
+				client.Close()
+				return false
+			default:
+			}
+			log.Println("connection: connected")
+			c.client = client
+			close(c.up)
+			return true
+		}
+		log.Printf("connection: %v, retrying in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-c.done:
+			return false
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
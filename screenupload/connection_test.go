@@ -0,0 +1,111 @@
+package screenupload
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it is true or fails the test after a while
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectionReconnects(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := server.Config()
+	cfg.KeepAlive = 20 * time.Millisecond
+
+	u := NewSCPUploader(cfg)
+	defer u.Close()
+	f, content := testUpload(t, "first.png", 1024)
+	err := u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(t, cfg, f, content)
+
+	server.Disconnect()
+	waitFor(t, "the reconnect", func() bool { return server.conns.Load() == 2 })
+
+	f, content = testUpload(t, "second.png", 1024)
+	err = u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(t, cfg, f, content)
+}
+
+func TestConnectionClose(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := server.Config()
+	cfg.KeepAlive = 10 * time.Millisecond
+
+	c := newConnection(cfg)
+	_, err := c.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if _, err := c.Client(context.Background()); err != errConnectionClosed {
+		t.Errorf("Client after Close: got %v, want %v", err, errConnectionClosed)
+	}
+
+	// a running keepalive loop would connect again
+	server.Disconnect()
+	time.Sleep(20 * cfg.KeepAlive)
+	if n := server.conns.Load(); n != 1 {
+		t.Errorf("%d connections after Close, want 1", n)
+	}
+}
+
+func TestConnectionClientTimeout(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := server.Config()
+	cfg.KeepAlive = time.Second
+	// nothing accepts connections there anymore
+	server.Close()
+
+	c := newConnection(cfg)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Client(ctx)
+	if err != errDisconnected {
+		t.Errorf("got %v, want %v", err, errDisconnected)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Client returned after %s", took)
+	}
+}
+
+func TestSendKeepaliveTimeout(t *testing.T) {
+	server := newTestSSHServer(t)
+	client, err := dial(server.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = sendKeepalive(client, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.noKeepalive.Store(true)
+	start := time.Now()
+	err = sendKeepalive(client, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("unanswered keepalive succeeded")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("sendKeepalive returned after %s", took)
+	}
+}
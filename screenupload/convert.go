@@ -0,0 +1,107 @@
+package screenupload
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// convertWebP encodes an image as WebP with cwebp into a temporary file and
+// returns it with the new extension. Files which aren't images and animated
+// GIFs are returned unchanged.
+func convertWebP(cfg Config, f File) (File, error) {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return File{}, err
+	}
+	defer r.Close()
+
+	img, format, err := image.Decode(r)
+	if err != nil {
+		// not an image we can decode, upload it as it is
+		return f, nil
+	}
+
+	src := f.Path
+	if format == "gif" {
+		_, err = r.Seek(0, 0)
+		if err != nil {
+			return File{}, err
+		}
+		g, err := gif.DecodeAll(r)
+		if err == nil && len(g.Image) > 1 {
+			log.Println("skipping conversion of animated gif", f.Name)
+			return f, nil
+		}
+		// cwebp only reads PNG, JPEG, TIFF and WebP
+		src, err = writePNG(cfg, img)
+		if err != nil {
+			return File{}, err
+		}
+		defer removeTemp(src)
+	}
+
+	tmp, err := createTemp(cfg, "screenupload-*.webp")
+	if err != nil {
+		return File{}, err
+	}
+	tmp.Close()
+
+	args := []string{"-quiet", "-q", strconv.Itoa(cfg.WebPQuality)}
+	if cfg.WebPLossless {
+		args = append(args, "-lossless")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("cwebp", append(args, src, "-o", tmp.Name())...)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		removeTemp(tmp.Name())
+		return File{}, fmt.Errorf("cwebp: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	before, after := fileSize(f.Path), fileSize(tmp.Name())
+	log.Printf("converted %s to webp, %s -> %s", f.Name, formatSize(before), formatSize(after))
+
+	converted := f
+	converted.Path = tmp.Name()
+	converted.Extension = ".webp"
+	converted.Name = strings.TrimSuffix(f.Name, f.Extension) + ".webp"
+	return converted, nil
+}
+
+// writePNG encodes an image as PNG into a temporary file and returns its
+// path
+func writePNG(cfg Config, img image.Image) (string, error) {
+	tmp, err := createTemp(cfg, "screenupload-*.png")
+	if err != nil {
+		return "", err
+	}
+	err = png.Encode(tmp, img)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		removeTemp(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// fileSize returns the size of a file or 0 if it can't be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,121 @@
+package screenupload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// dedupeEntry is a file uploaded before, identified by its content
+type dedupeEntry struct {
+	Hash string    `json:"hash"` // SHA256 of the content
+	URL  string    `json:"url"`
+	Time time.Time `json:"time"`
+}
+
+// dedupeMu serializes the access to the store within this process, other
+// processes only ever see a complete file as it is replaced atomically
+var dedupeMu sync.Mutex
+
+// dedupeStorePath returns the path of the store of uploaded files
+func dedupeStorePath(cfg Config) string {
+	if cfg.DedupeStore != "" {
+		return cfg.DedupeStore
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "screenupload-uploads.json")
+	}
+	return filepath.Join(dir, "screenupload", "uploads.json")
+}
+
+// contentHash returns the hex encoded SHA256 of a file
+func contentHash(p string) (string, error) {
+	r, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// previousUpload returns the URL a file with the given content hash was
+// uploaded to, it is empty if it wasn't uploaded within DedupeMaxAge
+func previousUpload(cfg Config, hash string) (string, error) {
+	dedupeMu.Lock()
+	defer dedupeMu.Unlock()
+	entries, err := loadDedupeStore(cfg)
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Hash == hash {
+			return entries[i].URL, nil
+		}
+	}
+	return "", nil
+}
+
+// recordUpload adds an uploaded file to the store, the oldest entries are
+// dropped beyond DedupeKeep
+func recordUpload(cfg Config, hash, url string) error {
+	dedupeMu.Lock()
+	defer dedupeMu.Unlock()
+	entries, err := loadDedupeStore(cfg)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, dedupeEntry{Hash: hash, URL: url, Time: time.Now()})
+	if cfg.DedupeKeep > 0 && len(entries) > cfg.DedupeKeep {
+		entries = entries[len(entries)-cfg.DedupeKeep:]
+	}
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	p := dedupeStorePath(cfg)
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, b, 0600)
+}
+
+// loadDedupeStore reads the store without the entries older than
+// DedupeMaxAge, oldest first. A missing store is empty.
+func loadDedupeStore(cfg Config) ([]dedupeEntry, error) {
+	b, err := os.ReadFile(dedupeStorePath(cfg))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []dedupeEntry
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DedupeMaxAge <= 0 {
+		return entries, nil
+	}
+	cutoff := time.Now().Add(-cfg.DedupeMaxAge)
+	fresh := entries[:0]
+	for _, e := range entries {
+		if e.Time.After(cutoff) {
+			fresh = append(fresh, e)
+		}
+	}
+	return fresh, nil
+}
@@ -0,0 +1,55 @@
+package screenupload
+
+import (
+	"github.com/atotto/clipboard"
+	"github.com/deckarep/gosx-notifier"
+)
+
+// Notification is a desktop notification about an upload
+type Notification struct {
+	Title    string
+	Subtitle string
+	Message  string
+	Link     string // URL opened by clicking the notification, if any
+}
+
+// Notifier sends desktop notifications
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// Clipboard receives the text copied after uploads
+type Clipboard interface {
+	WriteAll(text string) error
+}
+
+// DesktopNotifier sends all notifications, it can be replaced to send them
+// elsewhere or to record them in tests, see the screenuploadtest package
+var DesktopNotifier Notifier = osNotifier{}
+
+// SystemClipboard receives all clipboard content, it can be replaced like
+// DesktopNotifier
+var SystemClipboard Clipboard = osClipboard{}
+
+// osNotifier sends notifications with terminal-notifier on macOS
+type osNotifier struct{}
+
+func (osNotifier) Notify(n Notification) error {
+	g := gosxnotifier.NewNotification(n.Message)
+	g.Title = n.Title
+	g.Subtitle = n.Subtitle
+	g.Sender = "com.apple.Terminal"
+	g.Link = n.Link
+	return g.Push()
+}
+
+// osClipboard writes into the clipboard of the OS, it does nothing if there
+// is no clipboard utility, that is reported at startup
+type osClipboard struct{}
+
+func (osClipboard) WriteAll(text string) error {
+	if clipboard.Unsupported {
+		return nil
+	}
+	return clipboard.WriteAll(text)
+}
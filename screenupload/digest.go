@@ -0,0 +1,141 @@
+package screenupload
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// digestChallenge is the WWW-Authenticate: Digest challenge of an HTTP
+// server, see RFC 7616
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string // MD5 if empty
+	qop       string // auth if the server supports it, empty otherwise
+	nc        int    // requests sent with the nonce
+}
+
+// parseDigestChallenge returns the first digest challenge with a supported
+// algorithm of the WWW-Authenticate headers, SHA-256 is preferred
+func parseDigestChallenge(headers []string) (*digestChallenge, bool) {
+	var found *digestChallenge
+	for _, h := range headers {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(h), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		p := parseAuthParams(params)
+		c := &digestChallenge{
+			realm:     p["realm"],
+			nonce:     p["nonce"],
+			opaque:    p["opaque"],
+			algorithm: p["algorithm"],
+		}
+		for _, q := range strings.Split(p["qop"], ",") {
+			if strings.TrimSpace(q) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		if c.nonce == "" || c.hash() == nil || (p["qop"] != "" && c.qop == "") {
+			continue
+		}
+		if found == nil || strings.HasPrefix(strings.ToUpper(c.algorithm), "SHA-256") {
+			found = c
+		}
+	}
+	return found, found != nil
+}
+
+// parseAuthParams parses the comma separated name=value pairs of a
+// challenge, values may be quoted
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		var name string
+		name, s, _ = strings.Cut(s, "=")
+		name = strings.ToLower(strings.TrimSpace(strings.TrimLeft(name, ", ")))
+		s = strings.TrimLeft(s, " ")
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			s = s[min(i+1, len(s)):]
+		} else {
+			v, _, _ := strings.Cut(s, ",")
+			value.WriteString(strings.TrimSpace(v))
+			s = s[len(v):]
+		}
+		_, s, _ = strings.Cut(s, ",")
+		if name != "" {
+			params[name] = value.String()
+		}
+	}
+	return params
+}
+
+// hash returns the hash of the algorithm, nil if it isn't supported
+func (c *digestChallenge) hash() hash.Hash {
+	switch strings.ToUpper(c.algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return md5.New()
+	case "SHA-256", "SHA-256-SESS":
+		return sha256.New()
+	}
+	return nil
+}
+
+// h returns the hex encoded hash of the values joined by colons
+func (c *digestChallenge) h(values ...string) string {
+	h := c.hash()
+	h.Write([]byte(strings.Join(values, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// authorize sets the Authorization header answering the challenge
+func (c *digestChallenge) authorize(req *http.Request, user, password string) {
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+	b := make([]byte, 12)
+	rand.Read(b)
+	cnonce := hex.EncodeToString(b)
+	uri := req.URL.RequestURI()
+
+	ha1 := c.h(user, c.realm, password)
+	if strings.HasSuffix(strings.ToUpper(c.algorithm), "-SESS") {
+		ha1 = c.h(ha1, c.nonce, cnonce)
+	}
+	ha2 := c.h(req.Method, uri)
+	var response string
+	if c.qop != "" {
+		response = c.h(ha1, c.nonce, nc, cnonce, c.qop, ha2)
+	} else {
+		response = c.h(ha1, c.nonce, ha2)
+	}
+
+	q := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	auth := fmt.Sprintf("Digest username=%s, realm=%s, nonce=%s, uri=%s, response=%s", q(user), q(c.realm), q(c.nonce), q(uri), q(response))
+	if c.algorithm != "" {
+		auth += ", algorithm=" + c.algorithm
+	}
+	if c.qop != "" {
+		auth += fmt.Sprintf(", qop=%s, nc=%s, cnonce=%s", c.qop, nc, q(cnonce))
+	}
+	if c.opaque != "" {
+		auth += ", opaque=" + q(c.opaque)
+	}
+	req.Header.Set("Authorization", auth)
+}
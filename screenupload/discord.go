@@ -0,0 +1,111 @@
+package screenupload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// discordTimeout bounds a single webhook request
+const discordTimeout = 30 * time.Second
+
+// discordAttempts is how often a message is posted while Discord rate limits
+// the webhook
+const discordAttempts = 3
+
+// discordMaxWait is the longest rate limit which is waited out
+const discordMaxWait = time.Minute
+
+// discordMessage is the body of a webhook request
+type discordMessage struct {
+	Content string         `json:"content"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+// discordEmbed links the upload, images are shown inline
+type discordEmbed struct {
+	Title string        `json:"title"`
+	URL   string        `json:"url"`
+	Image *discordImage `json:"image,omitempty"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+// discordRateLimit is the body of a 429 response
+type discordRateLimit struct {
+	Message    string  `json:"message"`
+	RetryAfter float64 `json:"retry_after"` // seconds
+}
+
+// postDiscord posts an upload to DiscordWebhook, it waits and retries if the
+// webhook is rate limited
+func postDiscord(cfg Config, f File, originalName string) error {
+	t, err := template.New("discord").Parse(cfg.DiscordTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid DISCORD_TEMPLATE: %v", err)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, clipboardData{Name: originalName, URL: f.URL, Size: f.Size})
+	if err != nil {
+		return fmt.Errorf("invalid DISCORD_TEMPLATE: %v", err)
+	}
+
+	embed := discordEmbed{Title: originalName, URL: f.URL}
+	if strings.HasPrefix(mime.TypeByExtension(f.Extension), "image/") {
+		embed.Image = &discordImage{URL: f.URL}
+	}
+	b, err := json.Marshal(discordMessage{Content: buf.String(), Embeds: []discordEmbed{embed}})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: discordTimeout}
+	for attempt := 1; ; attempt++ {
+		wait, err := sendDiscord(client, cfg.DiscordWebhook, b)
+		if err == nil || wait == 0 {
+			return err
+		}
+		if attempt == discordAttempts || wait > discordMaxWait {
+			return fmt.Errorf("%v, retry after %s", err, wait)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// sendDiscord sends one webhook request, it returns how long to wait if
+// Discord rate limits the webhook
+func sendDiscord(client *http.Client, webhook string, body []byte) (time.Duration, error) {
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the error contains the URL and so the webhook token
+		return 0, fmt.Errorf("discord: request failed: %v", strings.Replace(err.Error(), webhook, "<webhook>", -1))
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var rl discordRateLimit
+		json.Unmarshal(b, &rl)
+		wait := time.Duration(rl.RetryAfter * float64(time.Second))
+		if s, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && wait == 0 {
+			wait = time.Duration(s * float64(time.Second))
+		}
+		if wait <= 0 {
+			wait = time.Second
+		}
+		return wait, fmt.Errorf("discord: rate limited")
+	case resp.StatusCode >= 300:
+		return 0, fmt.Errorf("discord: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return 0, nil
+}
@@ -0,0 +1,124 @@
+package screenupload
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/deckarep/gosx-notifier"
+)
+
+// check is a single diagnosis of the doctor command
+type check struct {
+	name     string
+	critical bool
+	hint     string
+	run      func() error
+}
+
+// Doctor checks the environment and configuration, prints a checklist and
+// reports whether all critical checks passed
+func Doctor(cfg Config) bool {
+	checks := []check{
+		{"filter regex is valid", true, "fix the FILTER regular expression", func() error {
+			_, err := regexp.Compile(cfg.Filter)
+			return err
+		}},
+		{"watch directory exists and is writable", true, "create LPATH or point it to an existing directory", func() error {
+			return checkWritable(cfg.LPath)
+		}},
+	}
+	if cfg.Archive != "" {
+		checks = append(checks, check{"archive directory is writable", true, "create ARCHIVE or fix its permissions", func() error {
+			return checkWritable(cfg.Archive)
+		}})
+	}
+	if cfg.Backend == "scp" && len(cfg.IdentityFiles) > 0 {
+		checks = append(checks, check{"identity files can be loaded", true, "fix the keys of IDENTITY_FILES or add them to the ssh agent", func() error {
+			_, err := identitySigners(cfg.IdentityFiles)
+			return err
+		}})
+	}
+	if cfg.Backend == "scp" {
+		addr := net.JoinHostPort(cfg.HostName, cfg.Port)
+		checks = append(checks,
+			check{"ssh agent is reachable and has keys", len(cfg.IdentityFiles) == 0 && !cfg.UseSystemSSH, "start ssh-agent, check SSH_AUTH_SOCK and add a key with ssh-add", func() error {
+				a, err := getAgent()
+				if err != nil {
+					return err
+				}
+				keys, err := a.List()
+				if err != nil {
+					return err
+				}
+				if len(keys) == 0 {
+					return errors.New("agent has no keys")
+				}
+				return nil
+			}},
+			check{"host resolves", true, "check HOST and your DNS", func() error {
+				_, err := net.LookupHost(cfg.HostName)
+				return err
+			}},
+			check{"port " + cfg.Port + " is open", true, "check PORT and that the server is reachable from this network", func() error {
+				conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+				if err != nil {
+					return err
+				}
+				return conn.Close()
+			}},
+		)
+	}
+	checks = append(checks,
+		check{"clipboard utility is available", false, "install xclip, xsel or wl-clipboard (Wayland)", func() error {
+			if clipboard.Unsupported {
+				return errors.New("no clipboard utility found")
+			}
+			return nil
+		}},
+		check{"notifier works", false, "notifications are only supported on macOS", func() error {
+			if runtime.GOOS != "darwin" {
+				return fmt.Errorf("unsupported on %s", runtime.GOOS)
+			}
+			n := gosxnotifier.NewNotification("Notifications are working.")
+			n.Title = "Screen Upload"
+			return n.Push()
+		}},
+	)
+
+	ok := true
+	for _, c := range checks {
+		err := c.run()
+		if err == nil {
+			fmt.Printf("✓ %s\n", c.name)
+			continue
+		}
+		fmt.Printf("✗ %s: %v\n  hint: %s\n", c.name, err, c.hint)
+		if c.critical {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// checkWritable checks that dir is a directory we can create files in
+func checkWritable(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	f, err := os.CreateTemp(dir, ".screenupload-doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
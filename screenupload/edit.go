@@ -0,0 +1,51 @@
+package screenupload
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// imageEditor returns the command used to edit files before the upload, it
+// defaults to a new instance of Preview on macOS. open -W only returns once
+// the instance it launched quits, an already running Preview would keep it
+// waiting until the user quits that one.
+func imageEditor(cfg Config) ([]string, error) {
+	if cfg.ImageEditor != "" {
+		return strings.Fields(cfg.ImageEditor), nil
+	}
+	if runtime.GOOS == "darwin" {
+		return []string{"open", "-W", "-n", "-a", "Preview"}, nil
+	}
+	return nil, errors.New("EDIT_BEFORE_UPLOAD requires IMAGE_EDITOR on this OS")
+}
+
+// edit opens a file in the image editor and waits until it is closed, it
+// reports whether the file was changed
+func edit(cfg Config, f File) (bool, error) {
+	editor, err := imageEditor(cfg)
+	if err != nil {
+		return false, err
+	}
+	before, err := os.Stat(f.Path)
+	if err != nil {
+		return false, err
+	}
+
+	log.Println("waiting for", f.Name, "to be edited")
+	cmd := exec.Command(editor[0], append(editor[1:], f.Path)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("editor output:\n%s", out)
+		return false, err
+	}
+
+	after, err := os.Stat(f.Path)
+	if err != nil {
+		return false, err
+	}
+	return !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size(), nil
+}
@@ -0,0 +1,77 @@
+package screenupload
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExportConfig formats the options of a config which differ from the
+// defaults as a config file for another machine. Options read from the
+// keychain are exported as their keyring: reference, the secrets stored
+// there have to be copied with -set-secret. Secrets in plain text are only
+// exported with secrets set, the resolved values of references too.
+func ExportConfig(c Config, secrets bool) string {
+	var buf bytes.Buffer
+	buf.WriteString("# go-screenupload configuration, import it with go-screenupload -import\n")
+	def := DefaultConfig()
+	for _, o := range options {
+		v := formatValue(o.Field(&c))
+		if v == formatValue(o.Field(&def)) {
+			continue
+		}
+		// the default watch directory depends on the machine
+		if o.Key == "lpath" && c.LPath == defaultScreenshotDir() {
+			continue
+		}
+		switch ref := c.resolved[o.Key]; {
+		case ref != "" && !secrets:
+			v = formatValue(&ref)
+		case secretOptions[o.Key] && !secrets:
+			fmt.Fprintf(&buf, "# %s is a secret, export with -export-secrets or store it with -set-secret\n", o.Key)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", o.Key, v)
+	}
+	return buf.String()
+}
+
+// ImportConfig validates an exported config file and writes it to path,
+// an existing file is only replaced if force is set. Keychain references
+// which can't be resolved on this machine are reported.
+func ImportConfig(b []byte, path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+	}
+
+	c := DefaultConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err := dec.Decode(&c)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+
+	for _, o := range options {
+		f, ok := o.Field(&c).(*string)
+		if !ok || !strings.HasPrefix(*f, KeyringPrefix) {
+			continue
+		}
+		if _, err := getSecret(*f); err != nil {
+			log.Printf("warning: %s references %s which can't be read from the keychain of this machine, store it with -set-secret %s: %v",
+				o.Key, *f, strings.TrimPrefix(*f, KeyringPrefix), err)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
@@ -0,0 +1,151 @@
+package screenupload
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	// register decoders for image.DecodeConfig
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// resolveSymlink returns the target of a symlink if symlinks are followed,
+// it reports false if the file is a symlink which should be skipped
+func resolveSymlink(cfg Config, f File) (File, bool) {
+	info, err := os.Lstat(f.Path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return f, true
+	}
+	if !cfg.FollowSymlinks {
+		log.Println("skipping symlink", f.Path)
+		return f, false
+	}
+	target, err := filepath.EvalSymlinks(f.Path)
+	if err != nil {
+		log.Printf("skipping symlink %s: %v", f.Path, err)
+		return f, false
+	}
+	f.Symlink = f.Path
+	f.Path = target
+	return f, true
+}
+
+// isDir reports whether path is a directory or a symlink to one
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// allowed reports whether a file matching the filter should be uploaded,
+// it logs why a file is skipped
+func allowed(cfg Config, f File) bool {
+	if cfg.MinDimensions != "" || cfg.MaxDimensions != "" {
+		w, h, err := imageDimensions(f.Path)
+		if err != nil {
+			// files which can't be decoded bypass the check
+			return true
+		}
+		if minW, minH, _ := parseDimensions(cfg.MinDimensions); cfg.MinDimensions != "" && (w < minW || h < minH) {
+			log.Printf("skipping %s, %dx%d is smaller than %s", f.Path, w, h, cfg.MinDimensions)
+			return false
+		}
+		if maxW, maxH, _ := parseDimensions(cfg.MaxDimensions); cfg.MaxDimensions != "" && (w > maxW || h > maxH) {
+			log.Printf("skipping %s, %dx%d is larger than %s", f.Path, w, h, cfg.MaxDimensions)
+			return false
+		}
+	}
+	return true
+}
+
+// compileExcludes compiles the exclude patterns
+func compileExcludes(patterns []string) ([]*regexp.Regexp, error) {
+	excludes := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXCLUDE pattern %q: %v", p, err)
+		}
+		excludes = append(excludes, re)
+	}
+	return excludes, nil
+}
+
+// excluded reports whether a file name matches any of the exclude patterns,
+// .meta files with the overrides of another file are always excluded
+func excluded(excludes []*regexp.Regexp, name string) bool {
+	if strings.HasSuffix(name, metaSuffix) {
+		return true
+	}
+	for _, re := range excludes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDimensions parses dimensions in the form WIDTHxHEIGHT
+func parseDimensions(s string) (width, height int, err error) {
+	_, err = fmt.Sscanf(s, "%dx%d", &width, &height)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid dimensions %q, expected WIDTHxHEIGHT", s)
+	}
+	return width, height, nil
+}
+
+// imageDimensions reads the width and height from the header of an image
+// without decoding all of it
+func imageDimensions(path string) (width, height int, err error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	c, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return c.Width, c.Height, nil
+}
+
+// LatestFile returns the most recently modified file in the watch directory
+// which matches the filter and isn't excluded
+func LatestFile(cfg Config) (string, error) {
+	filter, err := regexp.Compile(cfg.Filter)
+	if err != nil {
+		return "", fmt.Errorf("invalid FILTER: %v", err)
+	}
+	excludes, err := compileExcludes(cfg.Exclude)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(cfg.LPath)
+	if err != nil {
+		return "", err
+	}
+
+	var latest os.FileInfo
+	for _, e := range entries {
+		if e.IsDir() || !filter.MatchString(e.Name()) || excluded(excludes, e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if latest == nil || info.ModTime().After(latest.ModTime()) {
+			latest = info
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no file in %s matches FILTER %s", cfg.LPath, cfg.Filter)
+	}
+	return filepath.Join(cfg.LPath, latest.Name()), nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package screenupload
+
+import "syscall"
+
+// freeSpace returns the bytes available to the user on the file system of
+// dir
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,103 @@
+package screenupload
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// gitPushAttempts is how often a push is retried after rebasing on conflicts
+const gitPushAttempts = 3
+
+// GitUploader uploads files by committing them into a local clone of a git
+// repository and pushing it
+type GitUploader struct {
+	cfg Config
+	url *template.Template
+}
+
+// gitURLData is passed to the URL template of the git backend
+type gitURLData struct {
+	Name string // Name of the uploaded file
+	Path string // Path of the file within the repository
+}
+
+// NewGitUploader returns a GitUploader committing into the clone at GitRepo
+func NewGitUploader(cfg Config) (*GitUploader, error) {
+	u := &GitUploader{cfg: cfg}
+	if cfg.GitURLTemplate != "" {
+		t, err := template.New("url").Parse(cfg.GitURLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GIT_URL_TEMPLATE: %v", err)
+		}
+		u.url = t
+	}
+	return u, nil
+}
+
+// Upload copies a file into the repository, commits and pushes it
+func (u *GitUploader) Upload(f File) error {
+	dir := filepath.Join(u.cfg.GitRepo, remotePath(u.cfg, f))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, f.Name)
+	err = copyLocal(f.Path, dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.git("add", "--", dst)
+	if err != nil {
+		return err
+	}
+	_, err = u.git("commit", "-m", "Add "+f.Name, "--", dst)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; ; i++ {
+		_, err = u.git("push")
+		if err == nil || i == gitPushAttempts {
+			return err
+		}
+		log.Printf("git push failed, rebasing and retrying (%d/%d): %v", i, gitPushAttempts, err)
+		_, err = u.git("pull", "--rebase")
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// URL returns the URL of an uploaded file using the URL template
+func (u *GitUploader) URL(f File) string {
+	if u.url == nil {
+		return fmt.Sprintf("%s/%s", remoteURL(u.cfg, f), f.Name)
+	}
+	var buf bytes.Buffer
+	err := u.url.Execute(&buf, gitURLData{
+		Name: f.Name,
+		Path: filepath.ToSlash(filepath.Join(remotePath(u.cfg, f), f.Name)),
+	})
+	if err != nil {
+		log.Println("failed to render GIT_URL_TEMPLATE:", err)
+	}
+	return buf.String()
+}
+
+// git runs a git command in the repository
+func (u *GitUploader) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = u.cfg.GitRepo
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
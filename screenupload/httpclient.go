@@ -0,0 +1,60 @@
+package screenupload
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// headerTransport adds the configured headers to the requests of the HTTP
+// backends, headers set by the backend itself are kept
+type headerTransport struct {
+	header http.Header
+	next   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// newHTTPClient returns the client of an HTTP backend which sends UserAgent
+// and HTTPHeaders with every request
+func newHTTPClient(cfg Config, timeout time.Duration) (*http.Client, error) {
+	header, err := httpHeaders(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: timeout}
+	if len(header) > 0 {
+		client.Transport = &headerTransport{header: header, next: http.DefaultTransport}
+	}
+	return client, nil
+}
+
+// httpHeaders parses HTTPHeaders given as "Name: value", UserAgent replaces
+// a User-Agent among them
+func httpHeaders(cfg Config) (http.Header, error) {
+	header := make(http.Header)
+	for _, h := range cfg.HTTPHeaders {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		kv := strings.SplitN(h, ":", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid HTTP_HEADERS entry %q, expected Name: value", h)
+		}
+		header.Add(name, strings.TrimSpace(kv[1]))
+	}
+	if cfg.UserAgent != "" {
+		header.Set("User-Agent", cfg.UserAgent)
+	}
+	return header, nil
+}
@@ -0,0 +1,56 @@
+package screenupload
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// identitySigners loads the private keys of IdentityFiles in order.
+// Unreadable, invalid and encrypted keys are skipped with a warning, it
+// only fails if none of them could be loaded.
+func identitySigners(files []string) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, p := range files {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		s, err := loadIdentity(p)
+		if err != nil {
+			log.Printf("warning: skipping identity file %s: %v", p, err)
+			continue
+		}
+		signers = append(signers, s)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("none of the IDENTITY_FILES could be loaded")
+	}
+	return signers, nil
+}
+
+// loadIdentity parses an unencrypted private key, a leading ~/ is the home
+// directory
+func loadIdentity(p string) (ssh.Signer, error) {
+	if strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		p = filepath.Join(home, p[2:])
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ssh.ParsePrivateKey(b)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, fmt.Errorf("the key is encrypted, add it to the ssh agent instead")
+	}
+	return s, err
+}
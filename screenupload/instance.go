@@ -0,0 +1,75 @@
+package screenupload
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errInstanceLocked is returned by lockFile if another process holds the lock
+var errInstanceLocked = errors.New("locked by another process")
+
+// instanceLockPath returns the lock file of a watch directory, it is kept
+// in the user cache directory so it is never seen by the watcher
+func instanceLockPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	// two paths of the same directory share a lock
+	if p, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = p
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(cache, "screenupload", fmt.Sprintf("watch-%x.lock", sum[:8])), nil
+}
+
+// lockInstance makes sure that only one watcher watches dir, the lock is
+// held until release is called or the process exits
+func lockInstance(dir string) (release func(), err error) {
+	path, err := instanceLockPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(f)
+	if err == errInstanceLocked {
+		// the lock file contains the pid of the instance holding it
+		b, _ := io.ReadAll(f)
+		f.Close()
+		if pid := strings.TrimSpace(string(b)); pid != "" {
+			return nil, fmt.Errorf("another instance (pid %s) is already watching %s", pid, dir)
+		}
+		return nil, fmt.Errorf("another instance is already watching %s", dir)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+
+	// the file isn't removed on release, another instance may have it open
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return func() {
+		f.Truncate(0)
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package screenupload
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive lock of f without waiting, the kernel
+// releases it if the process dies
+func lockFile(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return errInstanceLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock of f
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package screenupload
+
+// isLocked reports whether err was caused by another process having the
+// file open, files can be renamed while they are open on unix
+func isLocked(err error) bool {
+	return false
+}
@@ -0,0 +1,19 @@
+package screenupload
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows system error codes of a file another process has open
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLocked reports whether err was caused by another process having the
+// file open, e.g. a screenshot tool still writing it
+func isLocked(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && (errno == errorSharingViolation || errno == errorLockViolation)
+}
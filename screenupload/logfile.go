@@ -0,0 +1,152 @@
+package screenupload
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logBackupFormat is the time format in the names of rotated log files
+const logBackupFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a log file which is renamed once it reaches maxSize, a
+// new file is started in its place
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenLogFile opens LogFile for appending, it is rotated according to
+// LogMaxSize, LogMaxBackups and LogMaxAge
+func OpenLogFile(cfg Config) (io.WriteCloser, error) {
+	if cfg.LogMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid LOG_MAX_SIZE %d", cfg.LogMaxSize)
+	}
+	r := &rotatingFile{
+		path:       cfg.LogFile,
+		maxSize:    int64(cfg.LogMaxSize) << 20,
+		maxBackups: cfg.LogMaxBackups,
+		maxAge:     cfg.LogMaxAge,
+	}
+	err := os.MkdirAll(filepath.Dir(r.path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = r.open()
+	if err != nil {
+		return nil, err
+	}
+	r.prune()
+	return r, nil
+}
+
+// open opens the log file for appending
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write writes a log line, the file is rotated first if the line doesn't fit
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to rotate the log file:", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// rotate renames the log file with the current time and starts a new one
+func (r *rotatingFile) rotate() error {
+	err := r.f.Close()
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.path)
+	backup := strings.TrimSuffix(r.path, ext) + "-" + time.Now().Format(logBackupFormat) + ext
+	err = os.Rename(r.path, backup)
+	if err != nil && !os.IsNotExist(err) {
+		// keep writing to the full file rather than losing lines
+		r.open()
+		return err
+	}
+	err = r.open()
+	if err != nil {
+		return err
+	}
+	go r.prune()
+	return nil
+}
+
+// prune removes rotated log files beyond maxBackups or older than maxAge,
+// zero disables either limit
+func (r *rotatingFile) prune() {
+	if r.maxBackups <= 0 && r.maxAge <= 0 {
+		return
+	}
+	dir := filepath.Dir(r.path)
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println("failed to remove old log files:", err)
+		return
+	}
+
+	type backup struct {
+		path string
+		t    time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(logBackupFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{filepath.Join(dir, name), t})
+	}
+	// newest first
+	sort.Slice(backups, func(i, j int) bool { return backups[i].t.After(backups[j].t) })
+	for n, b := range backups {
+		if (r.maxBackups > 0 && n >= r.maxBackups) || (r.maxAge > 0 && time.Since(b.t) > r.maxAge) {
+			os.Remove(b.path)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package screenupload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestName is the name of the manifest in the archive directory
+const manifestName = "manifest.json"
+
+// manifestEntry records the checksum of an archived file, entries are keyed
+// by the path relative to the archive
+type manifestEntry struct {
+	SHA256 string    `json:"sha256"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"` // Time the file was archived
+}
+
+// manifestMu serializes updates of the manifest within this process
+var manifestMu sync.Mutex
+
+// ArchiveProblem is an archived file which doesn't match the manifest
+type ArchiveProblem struct {
+	Path    string
+	Problem string
+}
+
+// isManifest reports whether a file in the archive directory is the
+// manifest or a temporary file of it
+func isManifest(name string) bool {
+	return name == manifestName || strings.HasPrefix(name, "."+manifestName)
+}
+
+// manifested reports whether an upload is recorded in the manifest, files
+// which stay where they are aren't archived
+func manifested(cfg Config, keepLocal bool) bool {
+	return cfg.ArchiveManifest && cfg.Archive != "" && !keepLocal
+}
+
+// updateManifest records the checksums of the added archived files and
+// forgets the removed ones, failures are only logged. Checksums computed
+// before are used instead of reading the files again.
+func updateManifest(cfg Config, added []File, removed []string) {
+	if !manifested(cfg, false) {
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	m, err := loadManifest(cfg)
+	if err != nil {
+		log.Println("warning: failed to update the archive manifest:", err)
+		return
+	}
+	if m == nil {
+		m = make(map[string]manifestEntry)
+	}
+	for _, f := range added {
+		hash := f.sha256
+		if hash == "" {
+			hash, err = contentHash(f.Path)
+			if err != nil {
+				log.Println("warning: failed to add to the archive manifest:", err)
+				continue
+			}
+		}
+		m[archiveKey(cfg, f.Path)] = manifestEntry{SHA256: hash, Size: fileSize(f.Path), Time: time.Now()}
+	}
+	for _, p := range removed {
+		delete(m, archiveKey(cfg, p))
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Println("warning: failed to update the archive manifest:", err)
+		return
+	}
+	err = writeFileAtomic(filepath.Join(cfg.Archive, manifestName), b, 0644)
+	if err != nil {
+		log.Println("warning: failed to update the archive manifest:", err)
+	}
+}
+
+// loadManifest reads the manifest of the archive, it is nil if there is none
+func loadManifest(cfg Config) (map[string]manifestEntry, error) {
+	b, err := os.ReadFile(filepath.Join(cfg.Archive, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]manifestEntry
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive manifest: %v", err)
+	}
+	return m, nil
+}
+
+// CheckArchive hashes the archived files again and returns the ones which
+// are missing, changed or not in the manifest
+func CheckArchive(cfg Config) ([]ArchiveProblem, error) {
+	if cfg.Archive == "" {
+		return nil, errors.New("checking the archive requires ARCHIVE")
+	}
+	m, err := loadManifest(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("there is no %s in %s, enable ARCHIVE_MANIFEST", manifestName, cfg.Archive)
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []ArchiveProblem
+	for _, name := range names {
+		e := m[name]
+		p := filepath.Join(cfg.Archive, filepath.FromSlash(name))
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			problems = append(problems, ArchiveProblem{p, "missing"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() != e.Size {
+			problems = append(problems, ArchiveProblem{p, fmt.Sprintf("size changed from %d to %d bytes", e.Size, info.Size())})
+			continue
+		}
+		hash, err := contentHash(p)
+		if err != nil {
+			return nil, err
+		}
+		if hash != e.SHA256 {
+			problems = append(problems, ArchiveProblem{p, "checksum mismatch"})
+		}
+	}
+
+	files, err := archivedFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if _, ok := m[f.Key]; !ok {
+			problems = append(problems, ArchiveProblem{f.Path, "not in the manifest"})
+		}
+	}
+	return problems, nil
+}
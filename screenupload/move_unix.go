@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package screenupload
+
+import (
+	"errors"
+	"syscall"
+)
+
+// crossDevice reports whether a rename failed because the target is on
+// another file system
+func crossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// transientRename reports whether a failed rename may succeed if it is
+// tried again, e.g. while a sync client has the file open
+func transientRename(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY) || errors.Is(err, syscall.EAGAIN)
+}
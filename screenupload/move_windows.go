@@ -0,0 +1,22 @@
+package screenupload
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is the Windows system error code of a move to another
+// volume
+const errorNotSameDevice syscall.Errno = 17
+
+// crossDevice reports whether a rename failed because the target is on
+// another volume
+func crossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
+
+// transientRename reports whether a failed rename may succeed if it is
+// tried again, e.g. while a sync client has the file open
+func transientRename(err error) bool {
+	return isLocked(err) || errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+}
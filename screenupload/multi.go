@@ -0,0 +1,298 @@
+package screenupload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// backendRetryDelay is the delay before the first retry of a failed upload
+// to a secondary destination, it doubles with every attempt
+var backendRetryDelay = 30 * time.Second
+
+// destination is one of the backends a MultiUploader uploads to
+type destination struct {
+	name string // config file of the destination, "main config" for the main one
+	cfg  Config
+	u    Uploader
+	main bool // the destination of the main config, per file overrides apply to it
+}
+
+// MultiUploader uploads every file to the main config and the destinations
+// configured in Backends. Only the primary destination is uploaded to
+// synchronously and provides the URL, the others get a temporary copy of
+// the file in the background and their failures are logged and retried.
+type MultiUploader struct {
+	cfg     Config
+	dests   []destination
+	primary int
+
+	wg        sync.WaitGroup
+	done      chan struct{} // closed by Close to cancel pending retries
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	failed []error // uploads to secondary destinations which gave up
+}
+
+// NewMultiUploader returns a MultiUploader for the main config and the
+// config files in cfg.Backends
+func NewMultiUploader(cfg Config) (*MultiUploader, error) {
+	if cfg.PrimaryBackend < 0 || cfg.PrimaryBackend > len(cfg.Backends) {
+		return nil, fmt.Errorf("PRIMARY_BACKEND %d is out of range, BACKENDS has %d files", cfg.PrimaryBackend, len(cfg.Backends))
+	}
+
+	main := cfg
+	main.Backends = nil
+	u, err := NewUploader(main)
+	if err != nil {
+		return nil, err
+	}
+	m := &MultiUploader{
+		cfg:     cfg,
+		dests:   []destination{{name: "main config", cfg: main, u: u, main: true}},
+		primary: cfg.PrimaryBackend,
+		done:    make(chan struct{}),
+	}
+	for _, path := range cfg.Backends {
+		c, err := loadBackendConfig(main, path)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		u, err := NewUploader(c)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		m.dests = append(m.dests, destination{name: path, cfg: c, u: u})
+	}
+
+	// POOL_MAX_CONNS caps the connections to the servers of all destinations
+	if main.PoolIdleTTL > 0 {
+		shared := newPool(main.PoolIdleTTL, main.PoolMaxConns)
+		for _, d := range m.dests {
+			if s, ok := d.u.(*SCPUploader); ok {
+				s.sharePool(shared)
+			}
+		}
+	}
+	return m, nil
+}
+
+// loadBackendConfig reads the config file of a destination, it only has to
+// set the options which differ from the main config
+func loadBackendConfig(main Config, path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	c := main
+	c.resolved = nil
+	err = yaml.Unmarshal(b, &c)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if len(c.Backends) > 0 {
+		return Config{}, fmt.Errorf("%s: BACKENDS can't be nested", path)
+	}
+	err = resolveSecrets(&c)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// file returns f with the remote path and URL of the destination, the
+// file carries those of the main config
+func (d destination) file(f File) File {
+	if d.main {
+		return f
+	}
+	rpath, rurl := d.cfg.RPath, d.cfg.RUrl
+	if d.cfg.VideoRPath != "" && isVideo(d.cfg, f) {
+		rpath, rurl = d.cfg.VideoRPath, d.cfg.VideoRUrl
+	}
+	f.RPath, f.RUrl = expandProject(rpath, f.Project), expandProject(rurl, f.Project)
+	return f
+}
+
+// Upload uploads a file to all destinations and returns the result of the
+// primary one
+func (m *MultiUploader) Upload(f File) error {
+	_, err := m.UploadLocation(f)
+	return err
+}
+
+// UploadLocation uploads a file to all destinations and returns the URL the
+// primary destination reported
+func (m *MultiUploader) UploadLocation(f File) (string, error) {
+	for i, d := range m.dests {
+		if i == m.primary {
+			continue
+		}
+		err := m.uploadBackground(d, f)
+		if err != nil {
+			log.Printf("failed to upload %s to %s: %v", f.Name, d.name, err)
+			m.fail(d, f, err)
+		}
+	}
+
+	d := m.dests[m.primary]
+	if lu, ok := d.u.(LocationUploader); ok {
+		return lu.UploadLocation(d.file(f))
+	}
+	return "", d.u.Upload(d.file(f))
+}
+
+// uploadBackground uploads a temporary copy of a file to a secondary
+// destination, the original may be archived or removed before it is done
+func (m *MultiUploader) uploadBackground(d destination, f File) error {
+	tmp, err := createTemp(m.cfg, "backend-*"+f.Extension)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	err = copyLocal(f.Path, tmp.Name())
+	if err != nil {
+		removeTemp(tmp.Name())
+		return err
+	}
+	f.Path = tmp.Name()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer removeTemp(f.Path)
+		m.retry(d, d.file(f))
+	}()
+	return nil
+}
+
+// retry uploads a file to a secondary destination, retrying up to
+// BackendRetries times with a doubling delay until Close is called
+func (m *MultiUploader) retry(d destination, f File) {
+	delay := backendRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := d.u.Upload(f)
+		if err == nil {
+			debugf("uploaded %s to %s", f.Name, d.name)
+			return
+		}
+		if attempt >= m.cfg.BackendRetries {
+			log.Printf("failed to upload %s to %s: %v", f.Name, d.name, Failure(err))
+			m.fail(d, f, err)
+			return
+		}
+		log.Printf("failed to upload %s to %s, retrying in %s: %v", f.Name, d.name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-m.done:
+			log.Printf("gave up uploading %s to %s on shutdown", f.Name, d.name)
+			m.fail(d, f, err)
+			return
+		}
+		delay *= 2
+	}
+}
+
+// fail records an upload to a secondary destination which gave up, Close
+// returns them
+func (m *MultiUploader) fail(d destination, f File, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed = append(m.failed, fmt.Errorf("failed to upload %s to %s: %v", f.Name, d.name, err))
+}
+
+// URL returns the URL of a file on the primary destination
+func (m *MultiUploader) URL(f File) string {
+	d := m.dests[m.primary]
+	return d.u.URL(d.file(f))
+}
+
+// Remove deletes a file from all destinations which support it
+func (m *MultiUploader) Remove(f File) error {
+	var errs []error
+	for _, d := range m.dests {
+		r, ok := d.u.(Remover)
+		if !ok {
+			continue
+		}
+		err := r.Remove(d.file(f))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", d.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Missing returns the files which don't exist on the primary destination
+func (m *MultiUploader) Missing(files []File) ([]File, error) {
+	d := m.dests[m.primary]
+	v, ok := d.u.(Verifier)
+	if !ok {
+		return nil, errors.New("the primary backend can't check remote files")
+	}
+	if d.main {
+		return v.Missing(files)
+	}
+	dfiles := make([]File, len(files))
+	for i, f := range files {
+		dfiles[i] = d.file(f)
+	}
+	return v.Missing(dfiles)
+}
+
+// CheckWritable checks all destinations which support it, it returns
+// errWritableUnsupported if none of them does
+func (m *MultiUploader) CheckWritable() error {
+	checked := false
+	for _, d := range m.dests {
+		wc, ok := d.u.(WritableChecker)
+		if !ok {
+			continue
+		}
+		checked = true
+		err := wc.CheckWritable()
+		if err != nil {
+			return fmt.Errorf("%s: %v", d.name, err)
+		}
+	}
+	if !checked {
+		return errWritableUnsupported
+	}
+	return nil
+}
+
+// Connected returns the state of the connection of the primary destination,
+// the others are retried on their own
+func (m *MultiUploader) Connected() <-chan struct{} {
+	if c, ok := m.dests[m.primary].u.(Connector); ok {
+		return c.Connected()
+	}
+	return alwaysConnected
+}
+
+// Close cancels pending retries, waits for running uploads and closes the
+// destinations. It returns the uploads to secondary destinations which
+// failed.
+func (m *MultiUploader) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+		for _, d := range m.dests {
+			if c, ok := d.u.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return errors.Join(m.failed...)
+}
@@ -0,0 +1,166 @@
+package screenupload
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fileBackend writes the config file of a file backend destination and
+// returns its path and destination directory
+func fileBackend(t *testing.T, rurl string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "backend.yml")
+	err := os.WriteFile(path, []byte("file_dest: "+dir+"\nrurl: "+rurl+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, dir
+}
+
+// testMultiConfig returns a config for the file backend with one more
+// file backend destination
+func testMultiConfig(t *testing.T) (cfg Config, mainDir, backendDir string) {
+	cfg = DefaultConfig()
+	cfg.Backend = "file"
+	cfg.FileDest = t.TempDir()
+	cfg.RUrl = "https://main.example.com"
+	cfg.TempDir = t.TempDir()
+	backend, dir := fileBackend(t, "https://backup.example.com")
+	cfg.Backends = []string{backend}
+	return cfg, cfg.FileDest, dir
+}
+
+// testFile writes a file to upload into a new temporary directory
+func testFile(t *testing.T, name, content string) File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return File{Path: path, Extension: filepath.Ext(name), Name: name, Size: int64(len(content))}
+}
+
+func TestMultiUploader(t *testing.T) {
+	for _, primary := range []int{0, 1} {
+		cfg, mainDir, backendDir := testMultiConfig(t)
+		cfg.PrimaryBackend = primary
+		u, err := NewUploader(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := testFile(t, "shot.png", "pixels")
+		err = u.Upload(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the original can be archived as soon as the primary is done
+		os.Remove(f.Path)
+		u.(*MultiUploader).Close()
+
+		for _, dir := range []string{mainDir, backendDir} {
+			b, err := os.ReadFile(filepath.Join(dir, "shot.png"))
+			if err != nil || string(b) != "pixels" {
+				t.Errorf("primary %d: %s has %q, %v", primary, dir, b, err)
+			}
+		}
+		want := []string{"https://main.example.com/shot.png", "https://backup.example.com/shot.png"}[primary]
+		if url := u.URL(f); url != want {
+			t.Errorf("primary %d: URL %s, want %s", primary, url, want)
+		}
+		if tmp, _ := os.ReadDir(cfg.TempDir); len(tmp) > 0 {
+			t.Errorf("primary %d: temporary copies left: %v", primary, tmp)
+		}
+
+		err = u.(Remover).Remove(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{mainDir, backendDir} {
+			if _, err := os.Stat(filepath.Join(dir, "shot.png")); !os.IsNotExist(err) {
+				t.Errorf("primary %d: not removed from %s: %v", primary, dir, err)
+			}
+		}
+	}
+}
+
+func TestMultiUploaderPrimaryOutOfRange(t *testing.T) {
+	cfg, _, _ := testMultiConfig(t)
+	cfg.PrimaryBackend = 2
+	_, err := NewUploader(cfg)
+	if err == nil || !strings.Contains(err.Error(), "PRIMARY_BACKEND") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// flakyUploader fails the first fails uploads
+type flakyUploader struct {
+	fails int32
+	calls atomic.Int32
+}
+
+func (u *flakyUploader) Upload(f File) error {
+	if u.calls.Add(1) <= u.fails {
+		return errors.New("server on fire")
+	}
+	return nil
+}
+
+func (u *flakyUploader) URL(f File) string { return "" }
+
+func TestMultiUploaderRetriesSecondary(t *testing.T) {
+	defer func(d time.Duration) { backendRetryDelay = d }(backendRetryDelay)
+	backendRetryDelay = time.Millisecond
+
+	for _, tc := range []struct {
+		fails, retries int32
+		calls          int32
+		failed         bool // Close reports the upload
+	}{
+		{fails: 2, retries: 3, calls: 3},
+		{fails: 5, retries: 1, calls: 2, failed: true},
+	} {
+		cfg, _, _ := testMultiConfig(t)
+		cfg.BackendRetries = int(tc.retries)
+		m, err := NewMultiUploader(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flaky := &flakyUploader{fails: tc.fails}
+		m.dests[1].u = flaky
+
+		// a failing secondary doesn't fail the upload
+		err = m.Upload(testFile(t, "shot.png", "pixels"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.wg.Wait()
+		if calls := flaky.calls.Load(); calls != tc.calls {
+			t.Errorf("%d failures with %d retries: %d attempts, want %d", tc.fails, tc.retries, calls, tc.calls)
+		}
+		if err := m.Close(); (err != nil) != tc.failed {
+			t.Errorf("%d failures with %d retries: Close = %v", tc.fails, tc.retries, err)
+		}
+	}
+}
+
+func TestMultiUploaderCheckWritableUnsupported(t *testing.T) {
+	cfg, _, _ := testMultiConfig(t)
+	m, err := NewMultiUploader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := range m.dests {
+		m.dests[i].u = &flakyUploader{}
+	}
+	if err := m.CheckWritable(); err != errWritableUnsupported {
+		t.Errorf("got %v, want %v", err, errWritableUnsupported)
+	}
+}
@@ -0,0 +1,75 @@
+package screenupload
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+// maxWaitBackoff is the upper bound of the delay between attempts to reach
+// the host at startup
+const maxWaitBackoff = 30 * time.Second
+
+// hostAddress returns the address the backend connects to, it is empty for
+// local backends
+func hostAddress(cfg Config) string {
+	switch cfg.Backend {
+	case "", "scp":
+		// HOST may be an alias of the SSH config
+		if cfg.UseSystemSSH {
+			return ""
+		}
+		return net.JoinHostPort(cfg.HostName, cfg.Port)
+	case "webdav":
+		u, err := url.Parse(cfg.WebDAVURL)
+		if err != nil || u.Host == "" {
+			return ""
+		}
+		if u.Port() != "" {
+			return u.Host
+		}
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		return net.JoinHostPort(u.Hostname(), port)
+	case "b2":
+		return "api.backblazeb2.com:443"
+	}
+	return ""
+}
+
+// WaitForNetwork waits up to WaitForNetwork until the host of the backend
+// accepts TCP connections, retrying with exponential backoff. It returns an
+// error if the host is still unreachable after the timeout.
+func WaitForNetwork(cfg Config) error {
+	addr := hostAddress(cfg)
+	if cfg.WaitForNetwork <= 0 || addr == "" {
+		return nil
+	}
+
+	deadline := time.Now().Add(cfg.WaitForNetwork)
+	backoff := time.Second
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return fmt.Errorf("%s is still unreachable after %s: %v", addr, cfg.WaitForNetwork, err)
+		}
+		if backoff < wait {
+			wait = backoff
+		}
+		log.Printf("waiting for %s: %v, retrying in %s", addr, err, wait.Round(100*time.Millisecond))
+		time.Sleep(wait)
+		backoff *= 2
+		if backoff > maxWaitBackoff {
+			backoff = maxWaitBackoff
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package screenupload
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+)
+
+// Default notification templates, a summary of a batch has a Count above 1
+// and a failed upload an Err
+const (
+	defaultNotifyTitle    = "Screen Upload"
+	defaultNotifySubtitle = "{{if .Err}}Upload of {{.Name}} failed{{else if gt .Count 1}}Uploaded {{.Count}} files, {{size .Size}}{{else}}Upload finished, {{size .Size}} in {{.Duration}}{{end}}"
+	defaultNotifyMessage  = "{{if .Err}}{{.Err}}{{else if gt .Count 1}}The URLs are now in your clipboard.{{else}}The URL is now in your clipboard.{{end}}"
+)
+
+// notifyErrLength is the number of characters of an error shown in a
+// failure notification
+const notifyErrLength = 100
+
+// notificationData is passed to the notification templates
+type notificationData struct {
+	Name     string        // Original name of the file, empty for a summary
+	URL      string        // URL of the uploaded file, one per line for a summary
+	Size     int64         // Size of the upload in bytes, the total for a summary
+	Duration time.Duration // Duration of the upload, the total for a summary
+	Count    int           // Number of uploads, 1 unless it is a summary
+	Err      string        // Error of a failed upload, empty on success
+}
+
+// notificationFuncs are the functions available in notification templates
+var notificationFuncs = template.FuncMap{"size": formatSize}
+
+// parseNotificationTemplates parses the title, subtitle and message templates
+func parseNotificationTemplates(cfg Config) ([]*template.Template, error) {
+	var ts []*template.Template
+	for _, o := range []struct{ name, text string }{
+		{"NOTIFY_TITLE", cfg.NotifyTitle},
+		{"NOTIFY_SUBTITLE", cfg.NotifySubtitle},
+		{"NOTIFY_MESSAGE", cfg.NotifyMessage},
+	} {
+		t, err := template.New(o.name).Funcs(notificationFuncs).Parse(o.text)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// pushNotification renders the notification templates and sends the
+// notification using the OS default notifier
+func pushNotification(cfg Config, d notificationData) error {
+	ts, err := parseNotificationTemplates(cfg)
+	if err != nil {
+		return err
+	}
+	d.Duration = d.Duration.Round(100 * time.Millisecond)
+	text := make([]string, len(ts))
+	for i, t := range ts {
+		var buf bytes.Buffer
+		err := t.Execute(&buf, d)
+		if err != nil {
+			return err
+		}
+		text[i] = buf.String()
+	}
+
+	n := Notification{Title: text[0], Subtitle: text[1], Message: text[2]}
+	if d.Count == 1 {
+		n.Link = d.URL
+	}
+	return DesktopNotifier.Notify(n)
+}
+
+// truncate shortens s to n characters, counting runes so a multi-byte
+// character isn't cut
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
@@ -0,0 +1,35 @@
+package screenupload_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/dewey/go-screenupload/screenupload"
+	"github.com/dewey/go-screenupload/screenupload/screenuploadtest"
+)
+
+func TestNotifyFailure(t *testing.T) {
+	notifier, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := screenupload.DefaultConfig()
+	cfg.NotifyTitle = "Hochladen: {{.Name}}"
+	screenupload.NotifyFailure(cfg, screenupload.File{Name: "shot.png"}, errors.New(strings.Repeat("ü", 150)))
+
+	n := notifier.Notifications()
+	if len(n) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(n))
+	}
+	if n[0].Title != "Hochladen: shot.png" {
+		t.Errorf("title %q doesn't use NOTIFY_TITLE", n[0].Title)
+	}
+	if n[0].Subtitle != "Upload of shot.png failed" {
+		t.Errorf("subtitle is %q", n[0].Subtitle)
+	}
+	// the error is shortened by characters, not bytes
+	if !utf8.ValidString(n[0].Message) || n[0].Message != strings.Repeat("ü", 100)+"…" {
+		t.Errorf("message is %q", n[0].Message)
+	}
+}
@@ -0,0 +1,20 @@
+package screenupload
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// extractText recognizes the text in an image by shelling out to tesseract
+func extractText(f File) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("tesseract", f.Path, "stdout")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,119 @@
+package screenupload
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overrideAttrPrefix is the prefix of extended attributes overriding the
+// configuration of a single file
+const overrideAttrPrefix = "user.screenupload."
+
+// metaSuffix is appended to the path of a file to get its .meta file
+const metaSuffix = ".meta"
+
+// overrides can be set per file in a <name>.meta file next to it
+type overrides struct {
+	Name  string   `yaml:"name"`
+	RPath string   `yaml:"rpath"`
+	RUrl  string   `yaml:"rurl"`
+	Tags  []string `yaml:"tags"`
+}
+
+// applyOverrides reads the overrides of a file from its extended attributes
+// or its .meta file, the .meta file takes precedence. Invalid overrides are
+// logged and ignored.
+func applyOverrides(f File) File {
+	o, meta, errs := readOverrides(f.Path)
+	for _, err := range errs {
+		log.Println(err)
+	}
+	f.meta = meta
+	if o.Name != "" {
+		f.NameOverride = o.Name
+	}
+	if o.RPath != "" {
+		f.RPath = o.RPath
+	}
+	if o.RUrl != "" {
+		f.RUrl = o.RUrl
+	}
+	f.Tags = mergeTags(f.Tags, o.Tags)
+	return f
+}
+
+// CheckOverrides returns the problems with the overrides of the file at
+// path, applying them ignores the invalid ones
+func CheckOverrides(path string) error {
+	_, _, errs := readOverrides(path)
+	return errors.Join(errs...)
+}
+
+// readOverrides returns the valid overrides of the file at path and the path
+// of its .meta file if it has one, errs describes the invalid ones
+func readOverrides(path string) (o overrides, meta string, errs []error) {
+	o = overrides{
+		Name:  getXattr(path, overrideAttrPrefix+"name"),
+		RPath: getXattr(path, overrideAttrPrefix+"rpath"),
+		RUrl:  getXattr(path, overrideAttrPrefix+"rurl"),
+	}
+
+	b, err := os.ReadFile(path + metaSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("failed to read overrides of %s: %v", path, err))
+	}
+	if err == nil {
+		meta = path + metaSuffix
+		err = yaml.Unmarshal(b, &o)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid overrides in %s.meta: %v", path, err))
+		}
+	}
+
+	if err := validName(o.Name); o.Name != "" && err != nil {
+		errs = append(errs, fmt.Errorf("ignoring name override of %s: %v", path, err))
+		o.Name = ""
+	}
+	var tags []string
+	for _, t := range o.Tags {
+		if err := ValidateTag(t); err != nil {
+			errs = append(errs, fmt.Errorf("ignoring tag in %s.meta: %v", path, err))
+			continue
+		}
+		tags = mergeTags(tags, []string{t})
+	}
+	o.Tags = tags
+	return o, meta, errs
+}
+
+// validName checks that a remote name override is a plain file name, it
+// must not point into another directory. Control characters like a newline
+// would break the SCP header.
+func validName(name string) error {
+	if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("%q is not a plain file name", name)
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%q contains control characters", name)
+	}
+	return nil
+}
+
+// removeMeta removes the .meta file of a file which left the watch
+// directory, so it isn't left behind
+func removeMeta(f File) {
+	if f.meta == "" {
+		return
+	}
+	err := os.Remove(f.meta)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("warning: failed to remove", f.meta, err)
+	}
+}
@@ -0,0 +1,57 @@
+package screenupload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ok   bool
+	}{
+		{"login-bug.png", true},
+		{"v1.2.png", true},
+		{"../login-bug.png", false},
+		{"/etc/passwd", false},
+		{"bugs/login.png", false},
+		{`bugs\login.png`, false},
+		{"..", false},
+		{"a..png", false},
+		{"bug; $(id) 'x'.png", true},
+		{"bug\n.png", false},
+		{"bug\x00.png", false},
+	} {
+		err := validName(tc.name)
+		if (err == nil) != tc.ok {
+			t.Errorf("validName(%q) = %v, want ok %t", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestApplyOverridesInvalidName(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "shot.png")
+	err := os.WriteFile(p, []byte("png"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(p+metaSuffix, []byte("name: ../../.ssh/authorized_keys\nrpath: /var/www/bugs\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := applyOverrides(File{Path: p})
+	if f.NameOverride != "" {
+		t.Errorf("name override %q was applied", f.NameOverride)
+	}
+	if f.RPath != "/var/www/bugs" {
+		t.Errorf("rpath override %q wasn't applied", f.RPath)
+	}
+	if f.meta != p+metaSuffix {
+		t.Errorf("meta file is %q", f.meta)
+	}
+	if err := CheckOverrides(p); err == nil {
+		t.Error("invalid name override not reported")
+	}
+}
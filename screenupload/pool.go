@@ -0,0 +1,196 @@
+package screenupload
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// poolCheckTimeout is how long an idle pooled connection may take to answer
+// the keepalive sent before it is reused
+const poolCheckTimeout = 5 * time.Second
+
+// pool keeps SSH connections open between uploads, one per user and host,
+// so uploads without a persistent connection don't dial every time. Idle
+// connections are closed after a TTL and the number of open connections
+// is capped, the least recently used idle one makes room for a new host.
+type pool struct {
+	ttl time.Duration
+	max int // maximum number of open connections, unlimited if zero
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signalled when a connection is released or closed
+	conns   map[string]*pooledConn
+	dialing int // dials in progress, they count towards max
+}
+
+// pooledConn is a connection of the pool
+type pooledConn struct {
+	key      string
+	client   *ssh.Client
+	refs     int // users of the connection, it is idle at zero
+	lastUsed time.Time
+	expire   *time.Timer // closes the connection once it was idle for the TTL
+}
+
+// newPool returns a pool closing connections after they were idle for ttl
+// and keeping at most max connections open
+func newPool(ttl time.Duration, max int) *pool {
+	p := &pool{ttl: ttl, max: max, conns: make(map[string]*pooledConn)}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// poolKey identifies the server and account of a connection
+func poolKey(cfg Config) string {
+	return cfg.UserName + "@" + net.JoinHostPort(cfg.HostName, cfg.Port)
+}
+
+// Get returns a connection to the server of cfg, reusing an open one. It
+// waits while the pool is full of connections in use. release has to be
+// called once the connection isn't used anymore.
+func (p *pool) Get(cfg Config) (client *ssh.Client, release func(), err error) {
+	key := poolKey(cfg)
+	p.mu.Lock()
+	for {
+		if c := p.conns[key]; c != nil {
+			c.refs++
+			idle := c.refs == 1
+			if c.expire != nil {
+				c.expire.Stop()
+				c.expire = nil
+			}
+			p.mu.Unlock()
+
+			// the server may have dropped a connection which was idle
+			if idle {
+				if err := sendKeepalive(c.client, poolCheckTimeout); err != nil {
+					debugf("pool: dropping dead connection to %s: %v", key, err)
+					p.Discard(c.client)
+					p.release(c)
+					p.mu.Lock()
+					continue
+				}
+			}
+			debugf("pool: reusing connection to %s", key)
+			return c.client, func() { p.release(c) }, nil
+		}
+		if p.max <= 0 || len(p.conns)+p.dialing < p.max || p.evictIdle() {
+			break
+		}
+		p.cond.Wait()
+	}
+	p.dialing++
+	p.mu.Unlock()
+
+	client, err = dial(cfg)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	if err != nil {
+		p.cond.Broadcast()
+		return nil, nil, err
+	}
+	c := p.conns[key]
+	if c != nil {
+		// another upload connected to the same server in the meantime
+		client.Close()
+		c.refs++
+		if c.expire != nil {
+			c.expire.Stop()
+			c.expire = nil
+		}
+	} else {
+		c = &pooledConn{key: key, client: client, refs: 1}
+		p.conns[key] = c
+	}
+	return c.client, func() { p.release(c) }, nil
+}
+
+// release marks a connection as unused by one user, it is closed once it
+// was idle for the TTL
+func (p *pool) release(c *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.refs--
+	c.lastUsed = time.Now()
+	if c.refs == 0 && p.conns[c.key] == c {
+		c.expire = time.AfterFunc(p.ttl, func() { p.expireIdle(c) })
+	}
+	p.cond.Broadcast()
+}
+
+// expireIdle closes a connection if it is still idle
+func (p *pool) expireIdle(c *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c.refs > 0 || p.conns[c.key] != c {
+		return
+	}
+	debugf("pool: closing idle connection to %s", c.key)
+	p.remove(c)
+}
+
+// evictIdle closes the least recently used idle connection to make room
+// for another one, it reports false if all of them are in use. p.mu has to
+// be held.
+func (p *pool) evictIdle() bool {
+	var oldest *pooledConn
+	for _, c := range p.conns {
+		if c.refs == 0 && (oldest == nil || c.lastUsed.Before(oldest.lastUsed)) {
+			oldest = c
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	debugf("pool: closing idle connection to %s, the pool is full", oldest.key)
+	p.remove(oldest)
+	return true
+}
+
+// remove closes a connection and drops it from the pool. p.mu has to be
+// held.
+func (p *pool) remove(c *pooledConn) {
+	if c.expire != nil {
+		c.expire.Stop()
+		c.expire = nil
+	}
+	c.client.Close()
+	delete(p.conns, c.key)
+	p.cond.Broadcast()
+}
+
+// Discard closes a broken connection, it isn't handed out again. Its users
+// still have to release it.
+func (p *pool) Discard(client *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		if c.client == client {
+			p.remove(c)
+			return
+		}
+	}
+	client.Close()
+}
+
+// Len returns the number of open connections
+func (p *pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Close closes all connections, the ones in use fail
+func (p *pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		p.remove(c)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package screenupload
+
+import (
+	"testing"
+	"time"
+)
+
+// testPoolConfig returns a config for server which pools connections
+// instead of keeping a persistent one
+func testPoolConfig(server *testSSHServer, ttl time.Duration) Config {
+	cfg := server.Config()
+	cfg.KeepAlive = 0
+	cfg.PoolIdleTTL = ttl
+	return cfg
+}
+
+func TestPoolReusesConnection(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := testPoolConfig(server, time.Minute)
+	u := NewSCPUploader(cfg)
+	defer u.Close()
+
+	for _, name := range []string{"first.png", "second.png"} {
+		f, content := testUpload(t, name, 1024)
+		err := u.Upload(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRemote(t, cfg, f, content)
+	}
+	if n := server.conns.Load(); n != 1 {
+		t.Errorf("%d connections for two uploads, want 1", n)
+	}
+
+	// a connection the server dropped is replaced
+	server.Disconnect()
+	f, content := testUpload(t, "third.png", 1024)
+	err := u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(t, cfg, f, content)
+	if n := server.conns.Load(); n != 2 {
+		t.Errorf("%d connections after the server dropped one, want 2", n)
+	}
+}
+
+func TestPoolEvictsIdleConnections(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := testPoolConfig(server, 20*time.Millisecond)
+	u := NewSCPUploader(cfg)
+	defer u.Close()
+
+	f, _ := testUpload(t, "first.png", 1024)
+	err := u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the idle connection to be closed", func() bool { return u.pool.Len() == 0 })
+
+	f, _ = testUpload(t, "second.png", 1024)
+	err = u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := server.conns.Load(); n != 2 {
+		t.Errorf("%d connections, want 2", n)
+	}
+}
+
+func TestPoolMaxConns(t *testing.T) {
+	first, second := newTestSSHServer(t), newTestSSHServer(t)
+	firstCfg, secondCfg := first.Config(), second.Config()
+	p := newPool(time.Minute, 1)
+	defer p.Close()
+
+	_, release, err := p.Get(firstCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// the idle connection to the first server makes room for the second
+	_, release, err = p.Get(secondCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Len(); n != 1 {
+		t.Errorf("%d open connections, want 1", n)
+	}
+	p.mu.Lock()
+	_, ok := p.conns[poolKey(firstCfg)]
+	p.mu.Unlock()
+	if ok {
+		t.Error("the idle connection to the first server is still open")
+	}
+
+	// while the second one is in use, the first server has to wait
+	got := make(chan error, 1)
+	go func() {
+		_, release, err := p.Get(firstCfg)
+		if err == nil {
+			release()
+		}
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatalf("Get returned while the pool was full: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get didn't return after a connection was released")
+	}
+	if n := first.conns.Load(); n != 2 {
+		t.Errorf("%d connections to the first server, want 2", n)
+	}
+}
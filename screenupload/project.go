@@ -0,0 +1,111 @@
+package screenupload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// projectData is passed to the RPath and RUrl templates
+type projectData struct {
+	Project string
+}
+
+// unsafeProjectChars are replaced in project names, they end up in paths
+// and URLs
+var unsafeProjectChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// checkProject checks ProjectDetect, ProjectPattern and the templates of
+// the remote paths and URLs
+func checkProject(cfg Config) error {
+	switch cfg.ProjectDetect {
+	case "", "env":
+	case "window":
+		if runtime.GOOS != "darwin" {
+			return errors.New("PROJECT_DETECT=window is only supported on macOS")
+		}
+	default:
+		return fmt.Errorf("unknown PROJECT_DETECT %q", cfg.ProjectDetect)
+	}
+	if _, err := regexp.Compile(cfg.ProjectPattern); err != nil {
+		return fmt.Errorf("invalid PROJECT_PATTERN: %v", err)
+	}
+	for _, s := range []string{cfg.RPath, cfg.RUrl, cfg.VideoRPath, cfg.VideoRUrl} {
+		if _, err := template.New("remote").Parse(s); err != nil {
+			return fmt.Errorf("invalid template %q: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// detectProject returns the current project according to ProjectDetect,
+// it is empty if none was detected
+func detectProject(cfg Config) string {
+	var s string
+	switch cfg.ProjectDetect {
+	case "env":
+		s = os.Getenv(cfg.ProjectEnv)
+	case "window":
+		title, err := frontWindowTitle()
+		if err != nil {
+			debugf("failed to get the title of the frontmost window: %v", err)
+			return ""
+		}
+		s = title
+	}
+
+	if cfg.ProjectPattern != "" && s != "" {
+		re, err := regexp.Compile(cfg.ProjectPattern)
+		if err != nil {
+			return ""
+		}
+		m := re.FindStringSubmatch(s)
+		switch {
+		case m == nil:
+			s = ""
+		case len(m) > 1:
+			s = m[1]
+		default:
+			s = m[0]
+		}
+	}
+
+	s = strings.Trim(unsafeProjectChars.ReplaceAllString(s, "-"), "-.")
+	debugf("detected project %q", s)
+	return s
+}
+
+// frontWindowTitle returns the title of the frontmost window on macOS, it
+// requires the accessibility permission
+func frontWindowTitle() (string, error) {
+	script := `tell application "System Events" to tell (first application process whose frontmost is true) to get name of front window`
+	out, err := exec.Command("osascript", "-e", script).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// expandProject renders a remote path or URL template with the project,
+// strings without a template are returned as they are
+func expandProject(s, project string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	t, err := template.New("remote").Parse(s)
+	if err != nil {
+		return s
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, projectData{Project: project})
+	if err != nil {
+		return s
+	}
+	return buf.String()
+}
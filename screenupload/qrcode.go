@@ -0,0 +1,67 @@
+package screenupload
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// qrCodeSize is the width and height of QR code images in pixels
+const qrCodeSize = 512
+
+// showQRCode shows a QR code of an URL in the terminal or opens it as an
+// image depending on QRCode
+func showQRCode(cfg Config, url string) error {
+	q, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	switch cfg.QRCode {
+	case "terminal":
+		// stderr, stdout only has the URL in one-shot mode
+		fmt.Fprint(os.Stderr, q.ToSmallString(false))
+		return nil
+	case "open":
+		png, err := q.PNG(qrCodeSize)
+		if err != nil {
+			return err
+		}
+		// the image viewer reads the file later, it is removed on shutdown
+		tmp, err := createTemp(cfg, "screenupload-qr-*.png")
+		if err != nil {
+			return err
+		}
+		_, err = tmp.Write(png)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		err = tmp.Close()
+		if err != nil {
+			return err
+		}
+		return openFile(tmp.Name())
+	}
+	return fmt.Errorf("unknown QR_CODE %q", cfg.QRCode)
+}
+
+// openFile opens a file with the default application
+func openFile(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
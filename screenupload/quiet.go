@@ -0,0 +1,81 @@
+package screenupload
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// quietHours reports whether a time is within quiet hours and when they end
+type quietHours func(time.Time) (bool, time.Time)
+
+// parseQuietHours parses QuietHours, comma separated daily ranges like
+// 22:00-07:00 which may span midnight
+func parseQuietHours(s string) (quietHours, error) {
+	type span struct{ start, end time.Duration } // since midnight
+	var spans []span
+	for _, v := range strings.Split(s, ",") {
+		r := strings.SplitN(strings.TrimSpace(v), "-", 2)
+		if len(r) != 2 {
+			return nil, fmt.Errorf("invalid QUIET_HOURS %q: use ranges like 22:00-07:00", s)
+		}
+		var sp [2]time.Duration
+		for i, t := range r {
+			p, err := time.Parse("15:04", strings.TrimSpace(t))
+			if err != nil {
+				return nil, fmt.Errorf("invalid QUIET_HOURS %q: use ranges like 22:00-07:00", s)
+			}
+			sp[i] = time.Duration(p.Hour())*time.Hour + time.Duration(p.Minute())*time.Minute
+		}
+		if sp[0] == sp[1] {
+			return nil, fmt.Errorf("invalid QUIET_HOURS %q: %s starts and ends at the same time", s, v)
+		}
+		spans = append(spans, span{sp[0], sp[1]})
+	}
+
+	return func(now time.Time) (bool, time.Time) {
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		since := now.Sub(midnight)
+		for _, sp := range spans {
+			switch {
+			case sp.start < sp.end && since >= sp.start && since < sp.end:
+				return true, midnight.Add(sp.end)
+			case sp.start > sp.end && since >= sp.start:
+				return true, midnight.AddDate(0, 0, 1).Add(sp.end)
+			case sp.start > sp.end && since < sp.end:
+				return true, midnight.Add(sp.end)
+			}
+		}
+		return false, time.Time{}
+	}, nil
+}
+
+// checkQuietHours validates QuietHours and QuietHoursMode
+func checkQuietHours(cfg Config) error {
+	switch cfg.QuietHoursMode {
+	case "", "queue", "notify":
+	default:
+		return fmt.Errorf("unknown QUIET_HOURS_MODE %q", cfg.QuietHoursMode)
+	}
+	if cfg.QuietHours == "" {
+		return nil
+	}
+	_, err := parseQuietHours(cfg.QuietHours)
+	return err
+}
+
+// silenced reports whether notifications are suppressed right now because
+// of quiet hours in notify mode
+func silenced(cfg Config) bool {
+	if cfg.QuietHours == "" || cfg.QuietHoursMode != "notify" {
+		return false
+	}
+	q, err := parseQuietHours(cfg.QuietHours)
+	if err != nil {
+		log.Println("warning:", err)
+		return false
+	}
+	quiet, _ := q(time.Now())
+	return quiet
+}
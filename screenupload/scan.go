@@ -0,0 +1,135 @@
+package screenupload
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scanProgressInterval is how often the startup scan logs its progress
+var scanProgressInterval = 5 * time.Second
+
+// scanned is a file found in the watch directory by the startup scan
+type scanned struct {
+	path    string
+	version fileVersion // the file when it was hashed
+	sha256  string      // checksum of the content, empty unless it is needed
+}
+
+// existingFiles returns the files in the watch directory which match, oldest
+// first
+func existingFiles(cfg Config, match func(name string) bool) ([]string, error) {
+	entries, err := os.ReadDir(cfg.LPath)
+	if err != nil {
+		return nil, err
+	}
+	type entry struct {
+		path    string
+		modTime time.Time
+	}
+	var files []entry
+	for _, e := range entries {
+		if e.IsDir() || !match(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, entry{filepath.Join(cfg.LPath, e.Name()), info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// scanHashes reports whether the startup scan hashes the files, the
+// checksums are only needed for DEDUPE and ARCHIVE_MANIFEST
+func scanHashes(cfg Config) bool {
+	return cfg.Dedupe || (cfg.ArchiveManifest && cfg.Archive != "" && !cfg.Preserve)
+}
+
+// scanExisting sends the files at paths to files and closes it once all of
+// them were sent or stop is closed. If their checksums are needed they are
+// hashed by ScanWorkers in parallel first and the progress is logged. The
+// capacity of files bounds how far the scan runs ahead of its consumer.
+func scanExisting(cfg Config, paths []string, files chan<- scanned, stop <-chan struct{}) {
+	defer close(files)
+	if !scanHashes(cfg) {
+		for _, p := range paths {
+			select {
+			case files <- scanned{path: p}:
+			case <-stop:
+				return
+			}
+		}
+		return
+	}
+
+	jobs := make(chan string)
+	var hashed int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < max(cfg.ScanWorkers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				s := scanned{path: p}
+				info, err := os.Stat(p)
+				if err == nil {
+					s.version = fileVersion{info.ModTime(), info.Size()}
+					s.sha256, err = contentHash(p)
+				}
+				if err != nil {
+					// the upload hashes it again or reports the problem
+					debugf("startup scan: failed to hash %s: %v", p, err)
+				}
+				mu.Lock()
+				hashed++
+				mu.Unlock()
+				select {
+				case files <- s:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(scanProgressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				mu.Lock()
+				log.Printf("startup scan: hashed %d of %d existing files, %d waiting for the upload", hashed, len(paths), len(files))
+				mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+send:
+	for _, p := range paths {
+		select {
+		case jobs <- p:
+		case <-stop:
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(done)
+	log.Printf("startup scan: hashed %d existing files", hashed)
+}
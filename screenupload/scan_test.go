@@ -0,0 +1,49 @@
+package screenupload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanExistingIsBounded(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LPath = t.TempDir()
+	cfg.Dedupe = true
+	cfg.ScanWorkers = 2
+	for i := 0; i < 10; i++ {
+		p := filepath.Join(cfg.LPath, fmt.Sprintf("shot-%d.png", i))
+		if err := os.WriteFile(p, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := existingFiles(cfg, func(name string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(chan scanned, 3)
+	stop := make(chan struct{})
+	defer close(stop)
+	go scanExisting(cfg, paths, files, stop)
+
+	// the scan waits while the queue is full
+	waitFor(t, "the queue to fill", func() bool { return len(files) == cap(files) })
+	time.Sleep(50 * time.Millisecond)
+	if n := len(files); n != cap(files) {
+		t.Fatalf("%d files queued, want %d", n, cap(files))
+	}
+
+	seen := make(map[string]bool)
+	for s := range files {
+		if s.sha256 == "" {
+			t.Errorf("%s wasn't hashed", s.path)
+		}
+		seen[s.path] = true
+	}
+	if len(seen) != len(paths) {
+		t.Errorf("got %d files, want %d", len(seen), len(paths))
+	}
+}
@@ -0,0 +1,43 @@
+package screenupload
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schedule returns the next time queued files are uploaded after a time
+type schedule func(time.Time) time.Time
+
+// parseSchedule parses UploadSchedule, an interval like 30m or comma
+// separated daily times like 12:00,18:00
+func parseSchedule(s string) (schedule, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid UPLOAD_SCHEDULE %q: the interval has to be positive", s)
+		}
+		return func(now time.Time) time.Time {
+			return now.Add(d)
+		}, nil
+	}
+
+	var times []time.Duration // since midnight
+	for _, v := range strings.Split(s, ",") {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid UPLOAD_SCHEDULE %q: use an interval like 30m or times like 12:00,18:00", s)
+		}
+		times = append(times, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return func(now time.Time) time.Time {
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		for _, t := range times {
+			if next := midnight.Add(t); next.After(now) {
+				return next
+			}
+		}
+		return midnight.AddDate(0, 0, 1).Add(times[0])
+	}, nil
+}
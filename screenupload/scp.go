@@ -0,0 +1,629 @@
+package screenupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/pkg/sftp"
+)
+
+// SCPUploader uploads files to a remote server via SCP or SFTP
+type SCPUploader struct {
+	cfg        Config
+	persistent *connection // shared connection used when keepalive is enabled
+	pool       *pool       // connections kept between uploads without keepalive
+
+	mu        sync.Mutex
+	protocol  string // protocol used for transfers, scp or sftp
+	systemSSH bool   // upload with OpenSSH, see UseSystemSSH and SystemSSHFallback
+}
+
+// NewSCPUploader returns an SCPUploader, it keeps a persistent connection to
+// the remote server if keepalive is enabled and pools the connections of
+// uploads otherwise
+func NewSCPUploader(cfg Config) *SCPUploader {
+	u := &SCPUploader{cfg: cfg, protocol: "scp", systemSSH: cfg.UseSystemSSH}
+	if cfg.Protocol == "sftp" {
+		u.protocol = "sftp"
+	}
+	switch {
+	case cfg.UseSystemSSH:
+	case cfg.KeepAlive > 0:
+		u.persistent = newConnection(cfg)
+	case cfg.PoolIdleTTL > 0:
+		u.pool = newPool(cfg.PoolIdleTTL, cfg.PoolMaxConns)
+	}
+	return u
+}
+
+// sharePool makes the uploader use p instead of its own pool, uploaders to
+// several servers share one to limit the connections in total
+func (u *SCPUploader) sharePool(p *pool) {
+	if u.pool == nil {
+		return
+	}
+	u.pool.Close()
+	u.pool = p
+}
+
+// errTransferTimeout is returned if a transfer takes longer than TransferTimeout
+var errTransferTimeout = errors.New("transfer timed out")
+
+// errReaderUnsupported is returned by UploadReader if uploads go through
+// OpenSSH, the file has to be uploaded with Upload
+var errReaderUnsupported = errors.New("uploading from a reader is not supported with OpenSSH")
+
+// Upload copies a file into the remote path, a timed out transfer is retried
+// once
+func (u *SCPUploader) Upload(f File) error {
+	err := u.upload(f)
+	if err == errTransferTimeout {
+		log.Printf("warning: transfer of %s timed out after %s, retrying", f.Name, u.cfg.TransferTimeout)
+		err = u.upload(f)
+	}
+	return err
+}
+
+// upload connects if necessary, copies a file and runs the post upload
+// command
+func (u *SCPUploader) upload(f File) error {
+	u.mu.Lock()
+	systemSSH := u.systemSSH
+	u.mu.Unlock()
+	if systemSSH {
+		return u.uploadSystemSSH(f)
+	}
+
+	var client *ssh.Client
+	if u.persistent != nil {
+		// an upload uses one session at a time on the shared connection
+		u.persistent.Acquire()
+		defer u.persistent.Release()
+		// waits until the persistent connection is (re)connected
+		c, err := u.persistentClient()
+		if err != nil {
+			return err
+		}
+		client = c
+	} else {
+		c, release, err := u.dial()
+		if _, ok := err.(*handshakeError); ok && u.cfg.SystemSSHFallback {
+			log.Println("warning: the built-in SSH client failed, falling back to OpenSSH:", err)
+			u.mu.Lock()
+			u.systemSSH = true
+			u.mu.Unlock()
+			return u.uploadSystemSSH(f)
+		}
+		if err != nil {
+			return err
+		}
+		defer release()
+		client = c
+	}
+
+	err := u.copyTimeout(client, f, func() error { return u.copy(client, f) })
+	if err != nil {
+		return err
+	}
+	return u.finish(client, f)
+}
+
+// finish changes the owner of an uploaded file and runs the post upload
+// command
+func (u *SCPUploader) finish(client *ssh.Client, f File) error {
+	if u.cfg.RemoteOwner != "" || u.cfg.RemoteGroup != "" {
+		err := chownRemote(u.cfg, client, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	// run post upload command, a failure here doesn't fail the upload
+	if u.cfg.RemotePostCmd != "" {
+		err := runPostCmd(u.cfg, client, f)
+		if err != nil {
+			log.Println("warning: remote post command failed:", err)
+		}
+	}
+	return nil
+}
+
+// UploadReader uploads size bytes read from r under the name and remote path
+// of f without a file on disk. In auto mode, r has to be an io.Seeker to
+// fall back to SFTP.
+func (u *SCPUploader) UploadReader(r io.Reader, size int64, f File) error {
+	u.mu.Lock()
+	systemSSH, protocol := u.systemSSH, u.protocol
+	u.mu.Unlock()
+	if systemSSH {
+		return errReaderUnsupported
+	}
+
+	client, release, err := u.connect()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = u.copyTimeout(client, f, func() error { return u.copyStream(client, r, size, f, protocol) })
+	if err != nil {
+		return err
+	}
+	return u.finish(client, f)
+}
+
+// copyStream writes the content of r with the given protocol, in auto mode
+// it switches to SFTP if the server doesn't support SCP and r can be read
+// again
+func (u *SCPUploader) copyStream(client *ssh.Client, r io.Reader, size int64, f File, protocol string) error {
+	if protocol == "sftp" {
+		return copyStreamSFTP(u.cfg, r, f, client)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		u.markDead(client)
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	err = copyStream(u.cfg, r, size, f, session)
+	if u.cfg.Protocol == "auto" && scpUnavailable(err) {
+		seeker, ok := r.(io.Seeker)
+		if !ok {
+			return err
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		log.Println("scp is not available on the server, falling back to sftp:", err)
+		u.mu.Lock()
+		u.protocol = "sftp"
+		u.mu.Unlock()
+		return copyStreamSFTP(u.cfg, r, f, client)
+	}
+	return err
+}
+
+// connect returns the persistent connection, a pooled or a new one,
+// release has to be called once it isn't used anymore
+func (u *SCPUploader) connect() (client *ssh.Client, release func(), err error) {
+	if u.persistent != nil {
+		u.persistent.Acquire()
+		c, err := u.persistentClient()
+		if err != nil {
+			u.persistent.Release()
+			return nil, nil, err
+		}
+		return c, u.persistent.Release, nil
+	}
+	return u.dial()
+}
+
+// dial returns a pooled connection or a new one if there is no pool,
+// release has to be called once it isn't used anymore
+func (u *SCPUploader) dial() (client *ssh.Client, release func(), err error) {
+	if u.pool != nil {
+		return u.pool.Get(u.cfg)
+	}
+	c, err := dial(u.cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, func() { c.Close() }, nil
+}
+
+// markDead drops a broken connection, the persistent connection reconnects
+// and a pooled one isn't reused
+func (u *SCPUploader) markDead(client *ssh.Client) {
+	switch {
+	case u.persistent != nil:
+		u.persistent.MarkDead(client)
+	case u.pool != nil:
+		u.pool.Discard(client)
+	default:
+		client.Close()
+	}
+}
+
+// persistentClient returns the client of the persistent connection, waiting
+// at most clientWait for it to reconnect
+func (u *SCPUploader) persistentClient() (*ssh.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clientWait)
+	defer cancel()
+	return u.persistent.Client(ctx)
+}
+
+// Connected returns a channel which is closed while the persistent
+// connection is up, without one it is always closed
+func (u *SCPUploader) Connected() <-chan struct{} {
+	u.mu.Lock()
+	systemSSH := u.systemSSH
+	u.mu.Unlock()
+	if u.persistent == nil || systemSSH {
+		return alwaysConnected
+	}
+	return u.persistent.Connected()
+}
+
+// Close closes the persistent connection and stops its keepalive loop or
+// closes the pooled connections
+func (u *SCPUploader) Close() error {
+	switch {
+	case u.persistent != nil:
+		return u.persistent.Close()
+	case u.pool != nil:
+		return u.pool.Close()
+	}
+	return nil
+}
+
+// Remove deletes an uploaded file from the server
+func (u *SCPUploader) Remove(f File) error {
+	client, release, err := u.connect()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %v", err)
+	}
+	defer c.Close()
+	return c.Remove(path.Join(remotePath(u.cfg, f), f.Name))
+}
+
+// URL returns the URL of an uploaded file
+func (u *SCPUploader) URL(f File) string {
+	return fmt.Sprintf("%s/%s", remoteURL(u.cfg, f), f.Name)
+}
+
+// Missing returns the files which don't exist on the server, they are
+// checked via SFTP on a single connection
+func (u *SCPUploader) Missing(files []File) ([]File, error) {
+	client, release, err := u.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sftp: %v", err)
+	}
+	defer c.Close()
+
+	var missing []File
+	for _, f := range files {
+		_, err := c.Stat(path.Join(remotePath(u.cfg, f), f.Name))
+		if os.IsNotExist(err) {
+			missing = append(missing, f)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// copy transfers a file with the current protocol, in auto mode it switches
+// to SFTP for good once the server turns out not to support SCP
+func (u *SCPUploader) copy(client *ssh.Client, f File) error {
+	u.mu.Lock()
+	protocol := u.protocol
+	u.mu.Unlock()
+
+	if protocol == "sftp" {
+		return copyFileSFTP(u.cfg, f, client)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		u.markDead(client)
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+
+	err = copyFile(u.cfg, f, session)
+	if u.cfg.Protocol == "auto" && scpUnavailable(err) {
+		log.Println("scp is not available on the server, falling back to sftp:", err)
+		u.mu.Lock()
+		u.protocol = "sftp"
+		u.mu.Unlock()
+		return copyFileSFTP(u.cfg, f, client)
+	}
+	return err
+}
+
+// copyTimeout runs copy to transfer f and aborts the transfer by closing
+// the connection if it takes longer than TransferTimeout
+func (u *SCPUploader) copyTimeout(client *ssh.Client, f File, copy func() error) error {
+	if u.cfg.TransferTimeout <= 0 {
+		return cleanupPartial(u.cfg, f, copy())
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- copy()
+	}()
+	select {
+	case err := <-done:
+		return cleanupPartial(u.cfg, f, err)
+	case <-time.After(u.cfg.TransferTimeout):
+	}
+
+	u.markDead(client)
+	<-done
+	removePartial(u.cfg, f)
+	return errTransferTimeout
+}
+
+// transferError is returned if a transfer failed after the remote file was
+// created, the file may be incomplete
+type transferError struct {
+	err error
+}
+
+func (e *transferError) Error() string {
+	return e.err.Error()
+}
+
+// cleanupPartial removes the remote file if err is a transferError, so a
+// failed upload never leaves a broken file at its URL. It returns err.
+func cleanupPartial(cfg Config, f File, err error) error {
+	if _, ok := err.(*transferError); ok {
+		removePartial(cfg, f)
+	}
+	return err
+}
+
+// removePartial removes the partially written remote file of an aborted
+// transfer on a new connection, failures are only logged
+func removePartial(cfg Config, f File) {
+	client, err := dial(cfg)
+	if err != nil {
+		log.Println("warning: failed to remove partial upload:", err)
+		return
+	}
+	defer client.Close()
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		log.Println("warning: failed to remove partial upload:", err)
+		return
+	}
+	defer c.Close()
+
+	err = c.Remove(path.Join(remotePath(cfg, f), f.Name))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("warning: failed to remove partial upload:", err)
+		return
+	}
+	if err == nil {
+		log.Println("removed the partial upload of", f.Name)
+	}
+}
+
+// scpUnavailable reports whether an SCP transfer failed because the server
+// has no scp command
+func scpUnavailable(err error) bool {
+	exitErr, ok := err.(*ssh.ExitError)
+	return ok && exitErr.ExitStatus() == 127
+}
+
+// copyFileSFTP copies a file to the remote path via SFTP
+func copyFileSFTP(cfg Config, f File, client *ssh.Client) error {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return copyStreamSFTP(cfg, r, f, client)
+}
+
+// copyStreamSFTP writes the content of r to the remote path of f via SFTP,
+// the mode is set explicitly so it doesn't depend on the umask of the server
+func copyStreamSFTP(cfg Config, r io.Reader, f File, client *ssh.Client) error {
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %v", err)
+	}
+	defer c.Close()
+
+	dst := path.Join(remotePath(cfg, f), f.Name)
+	w, err := c.Create(dst)
+	if os.IsPermission(err) {
+		return notWritableError(cfg, remotePath(cfg, f), err)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return &transferError{err}
+	}
+	err = w.Close()
+	if err == nil {
+		err = c.Chmod(dst, cfg.RemoteFileMode)
+	}
+	if err != nil {
+		return &transferError{err}
+	}
+	return nil
+}
+
+// copyFile copies a file to the remote path using the configured file mode
+func copyFile(cfg Config, f File, session *ssh.Session) error {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	info, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	return copyStream(cfg, r, info.Size(), f, session)
+}
+
+// copyStream writes size bytes of r to the remote path of f via SCP using
+// the configured file mode, SCP needs the size before the content. The
+// remote file is named f.Name, the name the URL is built from. The server
+// reports errors like a denied write in the protocol stream.
+func copyStream(cfg Config, r io.Reader, size int64, f File, session *ssh.Session) error {
+	err := scpCopy(session, r, size, cfg.RemoteFileMode, path.Join(remotePath(cfg, f), f.Name))
+	if err == nil || scpUnavailable(err) {
+		return err
+	}
+	if msg := scpMessage(err); permissionDenied(msg) {
+		return notWritableError(cfg, remotePath(cfg, f), err)
+	}
+	return &transferError{err}
+}
+
+// runPostCmd runs the configured post upload command on the remote server
+func runPostCmd(cfg Config, client *ssh.Client, f File) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(postCmd(cfg, path.Join(remotePath(cfg, f), f.Name)))
+	if len(out) > 0 {
+		log.Printf("remote post command output:\n%s", out)
+	}
+	return err
+}
+
+// postCmd returns the post upload command for the remote file dst, the path
+// is quoted for the shell as an override may give it any name
+func postCmd(cfg Config, dst string) string {
+	return strings.Replace(cfg.RemotePostCmd, "{}", shellQuote(dst), -1)
+}
+
+// getAgent will use the system ssh agent
+func getAgent() (agent.Agent, error) {
+	agentConn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	return agent.NewClient(agentConn), err
+}
+
+// dial connects to the remote server using the system ssh agent
+func dial(cfg Config) (*ssh.Client, error) {
+	// the keys of IDENTITY_FILES are tried in order before the keys of the
+	// agent, a single method is used as the client tries each method once
+	var keys []ssh.Signer
+	if len(cfg.IdentityFiles) > 0 {
+		signers, err := identitySigners(cfg.IdentityFiles)
+		if err != nil {
+			return nil, err
+		}
+		keys = signers
+	}
+	agent, err := getAgent()
+	if err != nil && len(keys) == 0 {
+		return nil, fmt.Errorf("failed to connect to SSH_AUTH_SOCK: %v", err)
+	}
+	haveAgent := err == nil
+
+	clientConfig := &ssh.ClientConfig{
+		User: cfg.UserName,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				if !haveAgent {
+					return keys, nil
+				}
+				signers, err := agent.Signers()
+				if err != nil {
+					log.Println("warning: failed to get the keys of the ssh agent:", err)
+				}
+				return append(keys[:len(keys):len(keys)], signers...), nil
+			}),
+		},
+	}
+	if cfg.ShowBanner {
+		clientConfig.BannerCallback = logBanner
+	}
+	if cfg.HostKeyFingerprint != "" {
+		clientConfig.HostKeyCallback = pinnedHostKey(cfg.HostKeyFingerprint)
+	} else {
+		clientConfig.HostKeyCallback, err = knownHostKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the handshake is done separately to tell its failures from network errors
+	addr := net.JoinHostPort(cfg.HostName, cfg.Port)
+	conn, err := net.DialTimeout("tcp", addr, cfg.ConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %v", err)
+	}
+	// a server which accepts the connection and stalls fails the handshake
+	if cfg.ConnectTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.ConnectTimeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, &handshakeError{err}
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// handshakeError is returned by dial if the server was reachable but the
+// SSH handshake or the authentication failed
+type handshakeError struct {
+	err error
+}
+
+func (e *handshakeError) Error() string {
+	return fmt.Sprintf("failed to dial: %v", e.err)
+}
+
+// pinnedHostKey returns a HostKeyCallback accepting only the key with the
+// SHA256 fingerprint, with or without the SHA256: prefix
+func pinnedHostKey(fingerprint string) ssh.HostKeyCallback {
+	want := "SHA256:" + strings.TrimPrefix(fingerprint, "SHA256:")
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		got := ssh.FingerprintSHA256(key)
+		if got != want {
+			return fmt.Errorf("host key of %s has fingerprint %s, expected %s", hostname, got, want)
+		}
+		return nil
+	}
+}
+
+// knownHostKey returns a HostKeyCallback accepting the keys listed for the
+// server in ~/.ssh/known_hosts
+func knownHostKey() (ssh.HostKeyCallback, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(home, ".ssh", "known_hosts")
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s, connect once with ssh to add the server or set HOST_KEY_FINGERPRINT: %v", path, err)
+	}
+	return callback, nil
+}
+
+// logBanner will log the banner message sent by the remote server
+func logBanner(message string) error {
+	log.Printf("server banner:\n%s", message)
+	return nil
+}
@@ -0,0 +1,293 @@
+package screenupload
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newTestKey returns a random ed25519 public key
+func newTestKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestPinnedHostKey(t *testing.T) {
+	key := newTestKey(t)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	for _, pin := range []string{fingerprint, strings.TrimPrefix(fingerprint, "SHA256:")} {
+		if err := pinnedHostKey(pin)("example.com:22", addr, key); err != nil {
+			t.Errorf("pin %s: key with matching fingerprint rejected: %v", pin, err)
+		}
+	}
+
+	other := newTestKey(t)
+	err := pinnedHostKey(fingerprint)("example.com:22", addr, other)
+	if err == nil {
+		t.Fatal("key with mismatching fingerprint accepted")
+	}
+	if !strings.Contains(err.Error(), ssh.FingerprintSHA256(other)) {
+		t.Errorf("error doesn't name the presented fingerprint: %v", err)
+	}
+}
+
+func TestKnownHostKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	_, err := knownHostKey()
+	if err == nil || !strings.Contains(err.Error(), "HOST_KEY_FINGERPRINT") {
+		t.Fatalf("missing known_hosts: got %v", err)
+	}
+
+	key := newTestKey(t)
+	line := knownhosts.Line([]string{knownhosts.Normalize("example.com:2222")}, key)
+	err = os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(line+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := knownHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+	if err := callback("example.com:2222", addr, key); err != nil {
+		t.Errorf("known key rejected: %v", err)
+	}
+	if err := callback("example.com:2222", addr, newTestKey(t)); err == nil {
+		t.Error("changed key accepted")
+	}
+	if err := callback("other.example.com:2222", addr, key); err == nil {
+		t.Error("key of an unknown host accepted")
+	}
+}
+
+func TestDialStalledHandshake(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := server.Config()
+	cfg.ConnectTimeout = 100 * time.Millisecond
+
+	// the server accepts the connection but never speaks SSH
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	cfg.Port = strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := dial(cfg)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if _, ok := err.(*handshakeError); !ok {
+			t.Errorf("got %v, want a handshake error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial hangs on a stalled handshake")
+	}
+}
+
+// testUpload writes random content into a local file of the given size and
+// returns it with the content
+func testUpload(t *testing.T, name string, size int) (File, []byte) {
+	t.Helper()
+	content := make([]byte, size)
+	_, err := rand.Read(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), name)
+	err = os.WriteFile(p, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return File{Path: p, Name: name, Extension: filepath.Ext(name)}, content
+}
+
+// checkRemote checks that the uploaded file of f has the content and mode
+func checkRemote(t *testing.T, cfg Config, f File, content []byte) {
+	t.Helper()
+	p := filepath.Join(cfg.RPath, f.Name)
+	got, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("remote file has %d bytes which differ from the %d uploaded", len(got), len(content))
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != cfg.RemoteFileMode {
+		t.Errorf("remote file has mode %s, want %s", info.Mode().Perm(), cfg.RemoteFileMode)
+	}
+}
+
+func TestSCPUploaderUpload(t *testing.T) {
+	for _, tc := range []struct {
+		protocol string
+		noSCP    bool
+	}{
+		{"scp", false},
+		{"sftp", false},
+		{"auto", false},
+		{"auto", true},
+	} {
+		t.Run(fmt.Sprintf("%s noscp=%t", tc.protocol, tc.noSCP), func(t *testing.T) {
+			server := newTestSSHServer(t)
+			server.noSCP.Store(tc.noSCP)
+			cfg := server.Config()
+			cfg.Protocol = tc.protocol
+			cfg.RemoteFileMode = 0640
+
+			u := NewSCPUploader(cfg)
+			for i, size := range []int{0, 1, 300 << 10} {
+				f, content := testUpload(t, fmt.Sprintf("upload-%d.png", i), size)
+				err := u.Upload(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkRemote(t, cfg, f, content)
+				if got, want := u.URL(f), "https://example.com/u/"+f.Name; got != want {
+					t.Errorf("URL = %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSCPUploaderUploadReader(t *testing.T) {
+	for _, protocol := range []string{"scp", "sftp"} {
+		t.Run(protocol, func(t *testing.T) {
+			server := newTestSSHServer(t)
+			cfg := server.Config()
+			cfg.Protocol = protocol
+
+			u := NewSCPUploader(cfg)
+			f, content := testUpload(t, "reader.txt", 100<<10)
+			err := u.UploadReader(bytes.NewReader(content), int64(len(content)), f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRemote(t, cfg, f, content)
+		})
+	}
+}
+
+func TestSCPUploaderUploadReaderFallback(t *testing.T) {
+	server := newTestSSHServer(t)
+	server.noSCP.Store(true)
+	cfg := server.Config()
+
+	u := NewSCPUploader(cfg)
+	f, content := testUpload(t, "fallback.txt", 4096)
+	err := u.UploadReader(bytes.NewReader(content), int64(len(content)), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(t, cfg, f, content)
+	if u.protocol != "sftp" {
+		t.Errorf("protocol after the fallback is %s, want sftp", u.protocol)
+	}
+}
+
+func TestSCPUploaderRemoteName(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := server.Config()
+	cfg.Protocol = "scp"
+
+	// the remote file is named like the URL, not like the local file
+	u := NewSCPUploader(cfg)
+	f, content := testUpload(t, "local-temp.png", 1024)
+	f.Name = "remote name.png"
+	err := u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(t, cfg, f, content)
+	if _, err := os.Stat(filepath.Join(cfg.RPath, "local-temp.png")); !os.IsNotExist(err) {
+		t.Error("remote file named like the local file")
+	}
+}
+
+func TestSCPUploaderRemoteError(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := server.Config()
+	cfg.Protocol = "scp"
+	cfg.RPath = filepath.Join(cfg.RPath, "missing")
+
+	u := NewSCPUploader(cfg)
+	f, _ := testUpload(t, "shot.png", 1024)
+	err := u.Upload(f)
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("got %v, want the error of the server", err)
+	}
+}
+
+func TestSCPUploaderRemove(t *testing.T) {
+	server := newTestSSHServer(t)
+	cfg := server.Config()
+
+	u := NewSCPUploader(cfg)
+	f, _ := testUpload(t, "remove.png", 10)
+	err := u.Upload(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.Remove(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.RPath, f.Name)); !os.IsNotExist(err) {
+		t.Errorf("remote file still exists: %v", err)
+	}
+}
+
+func TestPostCmdQuotesPath(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RemotePostCmd = "chmod 644 {} && ls {}"
+	got := postCmd(cfg, "/u/it's $(id).png")
+	want := `chmod 644 '/u/it'\''s $(id).png' && ls '/u/it'\''s $(id).png'`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
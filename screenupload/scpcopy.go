@@ -0,0 +1,93 @@
+package screenupload
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// scpError is an error the server side of scp reported in the protocol
+// stream
+type scpError struct {
+	msg string
+}
+
+func (e *scpError) Error() string {
+	return e.msg
+}
+
+// scpCopy writes size bytes of r with the given mode to the file dst on the
+// server by running scp in sink mode. The remote file is named exactly like
+// dst, independent of the name of the local file. The acknowledgement of
+// the server is checked after every step.
+func scpCopy(session *ssh.Session, r io.Reader, size int64, mode os.FileMode, dst string) error {
+	defer session.Close()
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = session.Start("scp -t " + shellQuote(dst))
+	if err != nil {
+		return err
+	}
+	acks := bufio.NewReader(out)
+	ack := func() error {
+		b, err := acks.ReadByte()
+		if err != nil {
+			// the command failed before speaking the protocol, like a
+			// server without scp
+			if err := session.Wait(); err != nil {
+				return err
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if b == 0 {
+			return nil
+		}
+		msg, _ := acks.ReadString('\n')
+		session.Wait()
+		return &scpError{msg: strings.TrimSpace(msg)}
+	}
+
+	err = ack()
+	if err == nil {
+		_, err = fmt.Fprintf(w, "C%04o %d %s\n", mode.Perm(), size, path.Base(dst))
+	}
+	if err == nil {
+		err = ack()
+	}
+	if err == nil {
+		_, err = io.CopyN(w, r, size)
+	}
+	if err == nil {
+		_, err = w.Write([]byte{0})
+	}
+	if err == nil {
+		err = ack()
+	}
+	if err != nil {
+		return err
+	}
+	w.Close()
+	return session.Wait()
+}
+
+// scpMessage returns the message of an error the server side of scp
+// reported, it is empty for other errors
+func scpMessage(err error) string {
+	var e *scpError
+	if !errors.As(err, &e) {
+		return ""
+	}
+	return e.msg
+}
@@ -0,0 +1,57 @@
+package screenupload
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// screenshotLocationInterval is how often the macOS screenshot location is
+// checked for changes
+const screenshotLocationInterval = 10 * time.Second
+
+// defaultScreenshotDir returns the directory the OS saves screenshots to,
+// it is used if LPATH is unset
+func defaultScreenshotDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		if dir, err := macScreenshotDir(); err == nil && dir != "" {
+			return dir
+		}
+		return filepath.Join(home, "Desktop")
+	case "windows":
+		return filepath.Join(home, "Pictures", "Screenshots")
+	}
+	pictures := filepath.Join(home, "Pictures")
+	if out, err := exec.Command("xdg-user-dir", "PICTURES").Output(); err == nil {
+		if dir := strings.TrimSpace(string(out)); dir != "" {
+			pictures = dir
+		}
+	}
+	return filepath.Join(pictures, "Screenshots")
+}
+
+// macScreenshotDir reads the location configured with
+// `defaults write com.apple.screencapture location`
+func macScreenshotDir() (string, error) {
+	out, err := exec.Command("defaults", "read", "com.apple.screencapture", "location").Output()
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(string(out))
+	if strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, dir[2:])
+	}
+	return dir, nil
+}
@@ -0,0 +1,141 @@
+// Package screenupload watches a directory for new screenshots, uploads them
+// to a remote host and puts their URL into the clipboard. It is used by the
+// go-screenupload command and can be embedded into other programs.
+package screenupload
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"text/template"
+)
+
+// Debug enables debug logging
+var Debug bool
+
+// Quiet disables notifications and the clipboard, the caller reports the
+// URL of an upload itself
+var Quiet bool
+
+// debugf logs a message if debug logging is enabled
+func debugf(format string, v ...interface{}) {
+	if Debug {
+		log.Printf("debug: "+format, v...)
+	}
+}
+
+// Setup creates the uploader and checks the optional tools, it disables
+// features whose tools are missing
+func Setup(c *Config) (Uploader, error) {
+	u, err := NewUploader(*c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ConvertTo != "" && c.ConvertTo != "webp" {
+		return nil, fmt.Errorf("unsupported CONVERT_TO format %q", c.ConvertTo)
+	}
+	switch c.ScheduleClipboard {
+	case "", "queue", "upload":
+	default:
+		return nil, fmt.Errorf("unknown SCHEDULE_CLIPBOARD %q", c.ScheduleClipboard)
+	}
+	if c.UploadSchedule != "" && c.ScheduleClipboard != "upload" && (c.ConvertTo != "" || c.CompressNonImages) {
+		log.Println("warning: CONVERT_TO and COMPRESS_NON_IMAGES change the name of uploads, the URLs copied when files are queued may be wrong, set SCHEDULE_CLIPBOARD to upload")
+	}
+	switch c.QRCode {
+	case "", "terminal", "open":
+	default:
+		return nil, fmt.Errorf("unknown QR_CODE %q", c.QRCode)
+	}
+	if err := checkVideo(*c); err != nil {
+		return nil, err
+	}
+	if err := checkQuietHours(*c); err != nil {
+		return nil, err
+	}
+	if err := checkConfirm(*c); err != nil {
+		return nil, err
+	}
+	if err := checkProject(*c); err != nil {
+		return nil, err
+	}
+	switch c.NameCase {
+	case "", "keep", "lower", "upper":
+	default:
+		return nil, fmt.Errorf("unknown NAME_CASE %q", c.NameCase)
+	}
+	if _, err := template.New("clipboard").Parse(c.ClipboardTemplate); err != nil {
+		return nil, fmt.Errorf("invalid CLIPBOARD_TEMPLATE: %v", err)
+	}
+	if _, err := parseNotificationTemplates(*c); err != nil {
+		return nil, fmt.Errorf("invalid notification template: %v", err)
+	}
+	if _, err := template.New("discord").Parse(c.DiscordTemplate); err != nil {
+		return nil, fmt.Errorf("invalid DISCORD_TEMPLATE: %v", err)
+	}
+	if _, err := template.New("archive").Parse(c.ArchiveNameTemplate); err != nil {
+		return nil, fmt.Errorf("invalid ARCHIVE_NAME_TEMPLATE: %v", err)
+	}
+
+	if err := checkArchiveRoutes(*c); err != nil {
+		return nil, err
+	}
+	if c.Archive != "" {
+		if err := archiveDir(*c); err != nil {
+			return nil, err
+		}
+	}
+	if c.MinFreeSpace > 0 {
+		dir := c.Archive
+		if dir == "" {
+			dir = c.LPath
+		}
+		if err := checkFreeSpace(*c, dir); err != nil {
+			log.Println("warning:", err)
+		}
+	}
+	if c.CheckRemoteWritable {
+		var err error
+		if wc, ok := u.(WritableChecker); ok {
+			err = wc.CheckWritable()
+		} else {
+			err = errWritableUnsupported
+		}
+		if err == errWritableUnsupported {
+			log.Printf("warning: CHECK_REMOTE_WRITABLE is not supported by the %s backend", c.Backend)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.EditBeforeUpload {
+		if _, err := imageEditor(*c); err != nil {
+			return nil, err
+		}
+	}
+
+	checkClipboard()
+
+	if c.OCR {
+		if _, err := exec.LookPath("tesseract"); err != nil {
+			log.Println("warning: tesseract not found, text extraction is disabled")
+			c.OCR = false
+		}
+	}
+
+	if c.ConvertTo == "webp" {
+		if _, err := exec.LookPath("cwebp"); err != nil {
+			log.Println("warning: cwebp not found, images are uploaded without conversion")
+			c.ConvertTo = ""
+		}
+	}
+
+	if c.VideoTranscode {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			log.Println("warning: ffmpeg not found, videos are uploaded without transcoding")
+			c.VideoTranscode = false
+		}
+	}
+	return u, nil
+}
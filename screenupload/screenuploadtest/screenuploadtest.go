@@ -0,0 +1,81 @@
+// Package screenuploadtest provides fakes of the notifier and clipboard of
+// the screenupload package which record what they receive, so programs
+// embedding the package can check the side effects of an upload.
+package screenuploadtest
+
+import (
+	"sync"
+
+	"github.com/dewey/go-screenupload/screenupload"
+)
+
+// Notifier records notifications instead of showing them
+type Notifier struct {
+	// Err is returned by Notify if it is set
+	Err error
+
+	mu            sync.Mutex
+	notifications []screenupload.Notification
+}
+
+// Notify records a notification
+func (n *Notifier) Notify(x screenupload.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notifications = append(n.notifications, x)
+	return n.Err
+}
+
+// Notifications returns the recorded notifications, oldest first
+func (n *Notifier) Notifications() []screenupload.Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]screenupload.Notification(nil), n.notifications...)
+}
+
+// Clipboard records the text written into the clipboard
+type Clipboard struct {
+	// Err is returned by WriteAll if it is set
+	Err error
+
+	mu    sync.Mutex
+	texts []string
+}
+
+// WriteAll records text
+func (c *Clipboard) WriteAll(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.texts = append(c.texts, text)
+	return c.Err
+}
+
+// Texts returns everything written into the clipboard, oldest first
+func (c *Clipboard) Texts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.texts...)
+}
+
+// Content returns the last text written into the clipboard, like reading
+// the clipboard
+func (c *Clipboard) Content() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.texts) == 0 {
+		return ""
+	}
+	return c.texts[len(c.texts)-1]
+}
+
+// Install replaces screenupload.DesktopNotifier and
+// screenupload.SystemClipboard with new fakes, restore puts the previous
+// ones back
+func Install() (n *Notifier, c *Clipboard, restore func()) {
+	prevNotifier, prevClipboard := screenupload.DesktopNotifier, screenupload.SystemClipboard
+	n, c = &Notifier{}, &Clipboard{}
+	screenupload.DesktopNotifier, screenupload.SystemClipboard = n, c
+	return n, c, func() {
+		screenupload.DesktopNotifier, screenupload.SystemClipboard = prevNotifier, prevClipboard
+	}
+}
@@ -0,0 +1,96 @@
+package screenupload
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// metaEnvPrefix is the prefix of environment variables added to the metadata
+const metaEnvPrefix = "META_"
+
+// Metadata is uploaded as a JSON sidecar file next to an upload
+type Metadata struct {
+	Name         string            `json:"name"`
+	OriginalName string            `json:"original_name"`
+	URL          string            `json:"url"`
+	Created      time.Time         `json:"created"`
+	Title        string            `json:"title,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Project      string            `json:"project,omitempty"`
+	Text         string            `json:"text,omitempty"`
+	Extra        map[string]string `json:"extra,omitempty"`
+}
+
+// newMetadata returns the metadata of an uploaded file with its tags, the
+// title, tags and any other META_* environment variables are added as
+// capture context
+func newMetadata(f File, originalName string) Metadata {
+	m := Metadata{
+		Name:         f.Name,
+		OriginalName: originalName,
+		URL:          f.URL,
+		Created:      time.Now(),
+		Title:        os.Getenv(metaEnvPrefix + "TITLE"),
+		Project:      f.Project,
+	}
+	m.Tags = mergeTags(m.Tags, f.Tags)
+	if tags := os.Getenv(metaEnvPrefix + "TAGS"); tags != "" {
+		for _, t := range strings.Split(tags, ",") {
+			m.Tags = mergeTags(m.Tags, []string{strings.TrimSpace(t)})
+		}
+	}
+	for _, env := range os.Environ() {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], metaEnvPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(kv[0], metaEnvPrefix))
+		if key == "title" || key == "tags" {
+			continue
+		}
+		if m.Extra == nil {
+			m.Extra = make(map[string]string)
+		}
+		m.Extra[key] = kv[1]
+	}
+	return m
+}
+
+// uploadSidecar uploads the metadata of a file as JSON next to the file with
+// the same base name, a temporary file is only written if the uploader can't
+// upload from a reader
+func uploadSidecar(cfg Config, u Uploader, f File, m Metadata) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	sidecar := f
+	sidecar.Extension = ".json"
+	sidecar.Name = strings.TrimSuffix(f.Name, f.Extension) + ".json"
+	if ru, ok := u.(ReaderUploader); ok {
+		return ru.UploadReader(bytes.NewReader(b), int64(len(b)), sidecar)
+	}
+
+	tmp, err := createTemp(cfg, "screenupload-*.json")
+	if err != nil {
+		return err
+	}
+	defer removeTemp(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	sidecar.Path = tmp.Name()
+	return u.Upload(sidecar)
+}
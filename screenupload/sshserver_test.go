@@ -0,0 +1,283 @@
+package screenupload
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server on 127.0.0.1 which accepts a
+// single client key and serves the sink side of scp and the sftp subsystem
+// on the local file system
+type testSSHServer struct {
+	t        *testing.T
+	listener net.Listener
+	hostKey  ssh.Signer
+	identity string // path of the private key accepted by the server
+
+	// noSCP makes scp exit with 127 like a server without the command
+	noSCP atomic.Bool
+	// noKeepalive leaves keepalive requests unanswered like a hung server
+	noKeepalive atomic.Bool
+	// conns counts the accepted connections
+	conns atomic.Int32
+
+	mu       sync.Mutex
+	commands []string
+	open     []net.Conn
+}
+
+// newTestSSHServer starts a server which is stopped when the test ends
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := filepath.Join(t.TempDir(), "id_ed25519")
+	err = os.WriteFile(identity, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ssh.NewSignerFromKey(clientPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{t: t, listener: l, hostKey: hostKey, identity: identity}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.PublicKey().Marshal()) {
+				return nil, fmt.Errorf("unknown key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	go s.serve(config)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Config returns a config for the scp backend which uploads into a new
+// temporary directory on the server, the directory is RPath
+func (s *testSSHServer) Config() Config {
+	// the key of IdentityFiles is the only one offered
+	s.t.Setenv("SSH_AUTH_SOCK", "")
+
+	addr := s.listener.Addr().(*net.TCPAddr)
+	cfg := DefaultConfig()
+	cfg.HostName = "127.0.0.1"
+	cfg.Port = strconv.Itoa(addr.Port)
+	cfg.UserName = "test"
+	cfg.IdentityFiles = []string{s.identity}
+	cfg.HostKeyFingerprint = ssh.FingerprintSHA256(s.hostKey.PublicKey())
+	cfg.RPath = s.t.TempDir()
+	cfg.RUrl = "https://example.com/u"
+	return cfg
+}
+
+// Commands returns the commands executed on the server, oldest first
+func (s *testSSHServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Close stops the server and drops all connections
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+	s.Disconnect()
+}
+
+// Disconnect drops all open connections, the server keeps accepting new ones
+func (s *testSSHServer) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.open {
+		c.Close()
+	}
+	s.open = nil
+}
+
+func (s *testSSHServer) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.open = append(s.open, conn)
+		s.mu.Unlock()
+		go s.handleConn(conn, config)
+	}
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.conns.Add(1)
+	go func() {
+		// keepalive requests
+		for req := range reqs {
+			if req.WantReply && !s.noKeepalive.Load() {
+				req.Reply(true, nil)
+			}
+		}
+	}()
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+// handleSession serves the first exec or subsystem request of a session
+func (s *testSSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		var payload struct{ Value string }
+		switch req.Type {
+		case "exec", "subsystem":
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		req.Reply(true, nil)
+
+		var status uint32
+		switch {
+		case req.Type == "subsystem" && payload.Value == "sftp":
+			server, err := sftp.NewServer(channel)
+			if err == nil {
+				server.Serve()
+			}
+		case req.Type == "subsystem":
+			status = 1
+		default:
+			s.mu.Lock()
+			s.commands = append(s.commands, payload.Value)
+			s.mu.Unlock()
+			status = s.exec(payload.Value, channel)
+		}
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, status)
+		channel.SendRequest("exit-status", false, b)
+		return
+	}
+}
+
+// exec runs a command of the session and returns its exit status, only scp
+// in sink mode is supported
+func (s *testSSHServer) exec(command string, channel ssh.Channel) uint32 {
+	args, err := shellquote.Split(command)
+	if err != nil || len(args) != 3 || args[0] != "scp" || args[1] != "-t" || s.noSCP.Load() {
+		fmt.Fprintf(channel.Stderr(), "sh: %s: command not found\n", command)
+		return 127
+	}
+	err = scpSink(channel, args[2])
+	if err != nil {
+		fmt.Fprintf(channel, "\x01scp: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// scpSink receives a single file like scp -t, target is a directory or the
+// path of the file
+func scpSink(rw io.ReadWriter, target string) error {
+	r := bufio.NewReader(rw)
+	ack := func() { rw.Write([]byte{0}) }
+	ack()
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	var mode os.FileMode
+	var size int64
+	var name string
+	_, err = fmt.Sscanf(strings.TrimSuffix(line, "\n"), "C%o %d %s", &mode, &size, &name)
+	if err != nil {
+		return fmt.Errorf("protocol error: %q", line)
+	}
+	dst := target
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		dst = filepath.Join(target, name)
+	}
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	ack()
+
+	_, err = io.CopyN(w, r, size)
+	if err != nil {
+		return err
+	}
+	if b, err := r.ReadByte(); err != nil || b != 0 {
+		return fmt.Errorf("%s: missing end of file", dst)
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	// the mode is only applied to new files by open
+	err = os.Chmod(dst, mode)
+	if err != nil {
+		return err
+	}
+	ack()
+	return nil
+}
@@ -0,0 +1,97 @@
+package screenupload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"os"
+)
+
+// hashingReader computes the SHA256 of the content read through it, so a
+// file is hashed while it is uploaded instead of being read twice
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64 // bytes hashed so far
+}
+
+// newHashingReader returns a hashingReader reading from r
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
+
+// Seek rewinds the underlying reader to the start and starts the hash over,
+// an upload falling back to another protocol reads the content again
+func (r *hashingReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.r.(io.Seeker)
+	if !ok || offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("hashingReader can only be rewound to the start")
+	}
+	pos, err := seeker.Seek(0, io.SeekStart)
+	if err != nil {
+		return pos, err
+	}
+	r.h.Reset()
+	r.n = 0
+	return pos, nil
+}
+
+// Sum returns the hex encoded SHA256 of the content read so far
+func (r *hashingReader) Sum() string {
+	return hex.EncodeToString(r.h.Sum(nil))
+}
+
+// uploadHashed uploads a file in a single pass through a hashingReader and
+// returns the SHA256 of its content. The checksum is empty if the uploader
+// didn't read the whole file.
+func uploadHashed(ru ReaderUploader, f File) (string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	r := newHashingReader(file)
+	err = ru.UploadReader(r, info.Size(), f)
+	if err != nil {
+		return "", err
+	}
+	if r.n != info.Size() {
+		return "", nil
+	}
+	return r.Sum(), nil
+}
+
+// uploadStreamed uploads a file and returns the SHA256 of its content if
+// the uploader can read it from a stream, it is hashed during the upload
+// then. Otherwise the file is uploaded with Upload and the checksum is
+// empty.
+func uploadStreamed(cfg Config, u Uploader, f File) (string, error) {
+	ru, ok := u.(ReaderUploader)
+	if !ok {
+		return "", u.Upload(f)
+	}
+	sum, err := uploadHashed(ru, f)
+	switch err {
+	case errReaderUnsupported:
+		return "", u.Upload(f)
+	case errTransferTimeout:
+		log.Printf("warning: transfer of %s timed out after %s, retrying", f.Name, cfg.TransferTimeout)
+		return uploadHashed(ru, f)
+	}
+	return sum, err
+}
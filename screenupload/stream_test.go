@@ -0,0 +1,144 @@
+package screenupload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// discardUploader reads uploads into io.Discard like a backend with an
+// infinitely fast connection
+type discardUploader struct{}
+
+func (discardUploader) Upload(f File) error {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+func (discardUploader) UploadReader(r io.Reader, size int64, f File) error {
+	_, err := io.CopyN(io.Discard, r, size)
+	return err
+}
+
+func (discardUploader) URL(f File) string { return "" }
+
+func TestUploadHashed(t *testing.T) {
+	f, _ := testUpload(t, "shot.png", 64<<10)
+	want, err := contentHash(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := uploadHashed(discardUploader{}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestHashingReaderRewind(t *testing.T) {
+	r := newHashingReader(bytes.NewReader([]byte("pixels")))
+	io.ReadAll(r)
+	first := r.Sum()
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(r)
+	if r.Sum() != first || r.n != 6 {
+		t.Errorf("hash after rewinding differs: %s, %d bytes", r.Sum(), r.n)
+	}
+	if _, err := r.Seek(2, io.SeekStart); err == nil {
+		t.Error("seeking into the middle accepted")
+	}
+}
+
+func TestUploadHashedSFTPFallback(t *testing.T) {
+	server := newTestSSHServer(t)
+	server.noSCP.Store(true)
+	cfg := server.Config()
+	u := NewSCPUploader(cfg)
+	defer u.Close()
+
+	// the content is read again for SFTP, the hash starts over
+	f, content := testUpload(t, "shot.png", 64<<10)
+	got, err := uploadStreamed(cfg, u, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(t, cfg, f, content)
+	want, err := contentHash(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestManifestUsesUploadChecksum(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Archive = t.TempDir()
+	cfg.ArchiveManifest = true
+	p := filepath.Join(cfg.Archive, "shot.png")
+	err := os.WriteFile(p, []byte("pixels"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a checksum from the upload isn't computed again
+	updateManifest(cfg, []File{{Path: p, sha256: "from-upload"}}, nil)
+	m, err := loadManifest(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := m[archiveKey(cfg, p)]; e.SHA256 != "from-upload" {
+		t.Errorf("manifest has %q", e.SHA256)
+	}
+}
+
+// benchmarkRecording writes a file of the size of a short screen recording
+func benchmarkRecording(b *testing.B) File {
+	b.Helper()
+	content := make([]byte, 32<<20)
+	rand.Read(content)
+	p := filepath.Join(b.TempDir(), "recording.mov")
+	err := os.WriteFile(p, content, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(content)))
+	return File{Path: p, Name: "recording.mov", Size: int64(len(content))}
+}
+
+// BenchmarkUploadHashThenCopy hashes the file and reads it again for the
+// upload
+func BenchmarkUploadHashThenCopy(b *testing.B) {
+	f := benchmarkRecording(b)
+	for b.Loop() {
+		if _, err := contentHash(f.Path); err != nil {
+			b.Fatal(err)
+		}
+		if err := (discardUploader{}).Upload(f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUploadHashed hashes the file while it is uploaded
+func BenchmarkUploadHashed(b *testing.B) {
+	f := benchmarkRecording(b)
+	for b.Loop() {
+		if _, err := uploadHashed(discardUploader{}, f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
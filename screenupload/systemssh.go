@@ -0,0 +1,109 @@
+package screenupload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"os/user"
+	"path"
+	"strings"
+)
+
+// uploadSystemSSH copies a file with the sftp command of OpenSSH instead of
+// the built-in client, see UseSystemSSH and SystemSSHFallback. It reads the SSH config of the user, so an existing
+// ControlMaster connection, ProxyJump and the agent are used as configured.
+func (u *SCPUploader) uploadSystemSSH(f File) error {
+	dst := path.Join(remotePath(u.cfg, f), f.Name)
+	debugf("uploading %s with the sftp command of OpenSSH", f.Name)
+	var batch bytes.Buffer
+	fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(f.Path), sftpQuote(dst))
+	fmt.Fprintf(&batch, "chmod %o %s\n", u.cfg.RemoteFileMode, sftpQuote(dst))
+
+	ctx := context.Background()
+	if u.cfg.TransferTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.cfg.TransferTimeout)
+		defer cancel()
+	}
+	args := append(systemSSHOptions(u.cfg, "-P"), "-b", "-", u.cfg.HostName)
+	cmd := exec.CommandContext(ctx, "sftp", args...)
+	cmd.Stdin = &batch
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		removePartialSystemSSH(u.cfg, dst)
+		return errTransferTimeout
+	}
+	if err != nil && permissionDenied(string(out)) {
+		return notWritableError(u.cfg, remotePath(u.cfg, f), fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out))))
+	}
+	if err != nil {
+		removePartialSystemSSH(u.cfg, dst)
+		return fmt.Errorf("sftp failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	if u.cfg.RemoteOwner != "" || u.cfg.RemoteGroup != "" {
+		spec := u.cfg.RemoteOwner
+		if u.cfg.RemoteGroup != "" {
+			spec += ":" + u.cfg.RemoteGroup
+		}
+		_, err := runSystemSSH(u.cfg, fmt.Sprintf("chown %s %s", shellQuote(spec), shellQuote(dst)))
+		if err != nil {
+			return fmt.Errorf("failed to change the owner of %s to %s, the SSH user needs permission to chown it: %v", dst, spec, err)
+		}
+	}
+
+	// run post upload command, a failure here doesn't fail the upload
+	if u.cfg.RemotePostCmd != "" {
+		out, err := runSystemSSH(u.cfg, postCmd(u.cfg, dst))
+		if len(out) > 0 {
+			log.Printf("remote post command output:\n%s", out)
+		}
+		if err != nil {
+			log.Println("warning: remote post command failed:", err)
+		}
+	}
+	return nil
+}
+
+// removePartialSystemSSH removes what a failed sftp command may have
+// written to dst, failures are only logged
+func removePartialSystemSSH(cfg Config, dst string) {
+	_, err := runSystemSSH(cfg, "rm -f "+shellQuote(dst))
+	if err != nil {
+		log.Println("warning: failed to remove partial upload:", err)
+	}
+}
+
+// runSystemSSH runs a command on the server with the ssh command of OpenSSH
+func runSystemSSH(cfg Config, command string) ([]byte, error) {
+	args := append(systemSSHOptions(cfg, "-p"), cfg.HostName, command)
+	out, err := exec.Command("ssh", args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// systemSSHOptions returns the options of the ssh and sftp commands. USER
+// and PORT are only passed if they differ from the defaults of ssh, so
+// User and Port of the SSH config apply otherwise. ssh uses -p for the port
+// and sftp -P.
+func systemSSHOptions(cfg Config, portFlag string) []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if cfg.Port != "" && cfg.Port != "22" {
+		args = append(args, portFlag, cfg.Port)
+	}
+	if cfg.UserName != "" {
+		if current, err := user.Current(); err != nil || current.Username != cfg.UserName {
+			args = append(args, "-o", "User="+cfg.UserName)
+		}
+	}
+	return args
+}
+
+// sftpQuote quotes an argument of an sftp batch command
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
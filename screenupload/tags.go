@@ -0,0 +1,34 @@
+package screenupload
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// tagPattern is what a tag may look like, it is safe in file names, URLs and
+// HTTP headers
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ValidateTag returns an error if a tag contains characters other than
+// letters, digits, '_', '.' and '-' or is longer than 64 characters
+func ValidateTag(tag string) error {
+	if !tagPattern.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q, use up to 64 letters, digits, '_', '.' and '-'", tag)
+	}
+	return nil
+}
+
+// mergeTags appends the tags of b to a which aren't in a yet
+func mergeTags(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, t := range a {
+		seen[t] = true
+	}
+	for _, t := range b {
+		if !seen[t] {
+			seen[t] = true
+			a = append(a, t)
+		}
+	}
+	return a
+}
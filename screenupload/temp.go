@@ -0,0 +1,78 @@
+package screenupload
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// tempFiles tracks the temporary files created by this process so they can
+// be removed on shutdown
+var tempFiles = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// createTemp creates a temporary file in the configured temp directory,
+// it has to be removed with removeTemp
+func createTemp(cfg Config, pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(cfg.TempDir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	tempFiles.Lock()
+	tempFiles.paths[f.Name()] = true
+	tempFiles.Unlock()
+	return f, nil
+}
+
+// removeTemp removes a temporary file created with createTemp
+func removeTemp(path string) {
+	tempFiles.Lock()
+	delete(tempFiles.paths, path)
+	tempFiles.Unlock()
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("failed to remove temp file:", err)
+	}
+}
+
+// CleanupTempFiles removes all temporary files which are left over
+func CleanupTempFiles() {
+	tempFiles.Lock()
+	paths := make([]string, 0, len(tempFiles.paths))
+	for p := range tempFiles.paths {
+		paths = append(paths, p)
+	}
+	tempFiles.Unlock()
+
+	for _, p := range paths {
+		removeTemp(p)
+	}
+}
+
+// writeFileAtomic writes a file by renaming a temporary file next to it
+// over it, readers never see a partially written file
+func writeFileAtomic(path string, b []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
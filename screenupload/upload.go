@@ -0,0 +1,633 @@
+package screenupload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/atotto/clipboard"
+)
+
+// renameRetryDelay is the delay before the first retry of a failed rename,
+// it grows with every attempt
+const renameRetryDelay = 250 * time.Millisecond
+
+// File contains all the information about a file
+type File struct {
+	Path      string
+	Extension string
+	Name      string
+	URL       string
+	Size      int64
+	Symlink   string   // Path of the symlink in the watch directory pointing to this file
+	Archived  bool     // The file is in the archive already and is uploaded without moving it
+	Tags      []string // Labels of the upload, they are added to the sidecar
+	Project   string   // Project detected when the file was found, see ProjectDetect
+	copied    bool     // The URL was copied to the clipboard when the file was queued
+	frames    []string // Frames of an assembled animation, archived once it is uploaded
+	meta      string   // Path of the .meta file the overrides were read from
+	sha256    string   // Checksum of the content if it was computed already, it isn't read again for the manifest
+
+	// per file overrides of the configuration
+	NameOverride string // Remote name instead of the generated one
+	RPath        string // Remote path instead of Config.RPath
+	RUrl         string // URL instead of Config.RUrl
+}
+
+// NewFile returns the File for a path with the per file overrides applied.
+// It reports false if the file is a symlink which isn't followed.
+func NewFile(cfg Config, path string) (File, bool) {
+	f, ok := resolveSymlink(cfg, File{
+		Path:      path,
+		Extension: filepath.Ext(path),
+		Name:      filepath.Base(path),
+	})
+	if !ok {
+		return File{}, false
+	}
+	// videos go to their own remote path unless the file overrides it
+	if cfg.VideoRPath != "" && isVideo(cfg, f) {
+		f.RPath, f.RUrl = cfg.VideoRPath, cfg.VideoRUrl
+	}
+	f = applyOverrides(f)
+
+	// the remote path and URL may depend on the project the user works on
+	if cfg.ProjectDetect != "" {
+		f.Project = detectProject(cfg)
+	}
+	if f.RPath == "" {
+		f.RPath = cfg.RPath
+	}
+	if f.RUrl == "" {
+		f.RUrl = cfg.RUrl
+	}
+	f.RPath, f.RUrl = expandProject(f.RPath, f.Project), expandProject(f.RUrl, f.Project)
+	return f, true
+}
+
+// Upload renames or archives a file, uploads it using the given uploader
+// and puts the URL into the clipboard
+func Upload(cfg Config, u Uploader, f File) (File, error) {
+	return upload(cfg, u, f, nil)
+}
+
+// uploadError is an error of the uploader. Unlike local failures like a
+// full disk or a failed rename it tells that the remote side has problems.
+type uploadError struct {
+	err error
+}
+
+func (e *uploadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// remoteFailure reports whether err is a failure of the uploader
+func remoteFailure(err error) bool {
+	var e *uploadError
+	return errors.As(err, &e)
+}
+
+// upload uploads a file, its notification is added to batch if it isn't nil
+func upload(cfg Config, u Uploader, f File, batch *batcher) (File, error) {
+	// rename or rename and archive if enabled
+	fn, err := rename(cfg, f)
+	if err != nil {
+		return File{}, err
+	}
+
+	// videos skip the steps made for images
+	process := processed(cfg, fn)
+
+	// archived and preserved files stay as they are
+	keepLocal := f.Archived || cfg.Preserve
+
+	// let the user annotate the file, the edited file is uploaded and archived
+	fn.sha256 = f.sha256
+	if cfg.EditBeforeUpload && process && !cfg.Preserve {
+		changed, err := edit(cfg, fn)
+		if err != nil {
+			log.Println("warning: editing failed, uploading the file as it is:", err)
+		} else if changed {
+			log.Println("uploading the edited", fn.Name)
+			fn.sha256 = ""
+		} else {
+			log.Println(fn.Name, "is unchanged")
+		}
+	}
+
+	// a file uploaded before isn't uploaded again, its previous URL is used
+	var hash, previous string
+	if cfg.Dedupe && !f.Archived {
+		hash, previous, err = findDuplicate(cfg, fn)
+		if err != nil {
+			log.Println("warning: duplicate check failed:", err)
+		}
+		fn.sha256 = hash
+	}
+
+	// convert or compress into a temporary file, the renamed file stays as it is
+	renamed := fn
+	if cfg.ConvertTo == "webp" && previous == "" && process {
+		converted, err := convertWebP(cfg, renamed)
+		if err != nil {
+			log.Println("warning: conversion failed, uploading the original:", err)
+		} else if converted.Path != renamed.Path {
+			defer removeTemp(converted.Path)
+			fn = converted
+		}
+	}
+	if cfg.VideoTranscode && previous == "" && isVideo(cfg, renamed) {
+		transcoded, err := transcodeVideo(cfg, renamed)
+		if err != nil {
+			log.Println("warning: transcoding failed, uploading the original:", err)
+		} else if transcoded.Path != renamed.Path {
+			defer removeTemp(transcoded.Path)
+			fn = transcoded
+		}
+	}
+	if cfg.CompressNonImages && previous == "" && process {
+		compressed, err := compressGzip(cfg, fn)
+		if err != nil {
+			log.Println("warning: compression failed, uploading the original:", err)
+		} else if compressed.Path != fn.Path {
+			defer removeTemp(compressed.Path)
+			fn = compressed
+		}
+	}
+
+	info, err := os.Stat(fn.Path)
+	if err != nil {
+		return File{}, err
+	}
+	fn.Size = info.Size()
+
+	// the URL reported by the backend is preferred over the templated one
+	var location string
+	start := time.Now()
+	if lu, ok := u.(LocationUploader); ok && previous == "" {
+		location, err = lu.UploadLocation(fn)
+		if err != nil {
+			return File{}, &uploadError{err}
+		}
+	} else if previous == "" && manifested(cfg, keepLocal) && fn.Path == renamed.Path && renamed.sha256 == "" {
+		// the manifest gets the checksum computed during the upload
+		renamed.sha256, err = uploadStreamed(cfg, u, fn)
+		if err != nil {
+			return File{}, &uploadError{err}
+		}
+	} else if previous == "" {
+		err = u.Upload(fn)
+		if err != nil {
+			return File{}, &uploadError{err}
+		}
+	}
+	took := time.Since(start)
+
+	// extract text before the file might get removed
+	var text string
+	if cfg.OCR && process {
+		text, err = extractText(renamed)
+		if err != nil {
+			log.Println("warning: text extraction failed:", err)
+		}
+	}
+
+	url := previous
+	if url == "" {
+		url = location
+	}
+	if url == "" {
+		url = u.URL(fn)
+	}
+
+	// let the user decide before the URL is copied and the file removed
+	confirmed := true
+	if cfg.Confirm != "" {
+		confirmed, err = confirm(cfg, f.Name, url)
+		if err != nil {
+			log.Println("warning: confirmation failed, copying the URL:", err)
+		}
+	}
+
+	// remove renamed file after upload, otherwise roll off old archived
+	// files. Files uploaded again from the archive and preserved files are
+	// left alone.
+	if cfg.Archive == "" && !keepLocal && confirmed {
+		err := trash(cfg, renamed)
+		if err != nil {
+			return File{}, err
+		}
+	} else if cfg.Archive == "" && !keepLocal {
+		log.Println("keeping", renamed.Path)
+	} else if !keepLocal {
+		updateManifest(cfg, []File{renamed}, nil)
+		pruneArchive(cfg, renamed.Path)
+	}
+
+	// the overrides were used up with the file, the target of a symlink
+	// isn't ours
+	if !keepLocal && f.Symlink == "" {
+		removeMeta(f)
+	}
+
+	// only the gif is uploaded, the frames are kept in the archive
+	if len(f.frames) > 0 {
+		archiveFrames(cfg, f.frames)
+	}
+
+	// send notification using OS default notifier
+	fn.URL = url
+	if previous != "" {
+		log.Printf("%s %s to %s", colorize(colorGreen, "already uploaded"), f.Name, colorize(colorBold, fn.URL))
+	} else {
+		log.Printf("%s %s to %s", colorize(colorGreen, "uploaded"), f.Name, colorize(colorBold, fn.URL))
+		if hash != "" {
+			err := recordUpload(cfg, hash, fn.URL)
+			if err != nil {
+				log.Println("warning: failed to record the upload for DEDUPE:", err)
+			}
+		}
+	}
+
+	// upload metadata next to the file, a failure here doesn't fail the upload
+	if cfg.Sidecar && previous == "" {
+		m := newMetadata(fn, f.Name)
+		m.Text = text
+		err := uploadSidecar(cfg, u, fn, m)
+		if err != nil {
+			log.Println("warning: sidecar upload failed:", err)
+		}
+	}
+
+	clip, err := clipboardText(cfg, fn, f.Name)
+	if err != nil {
+		log.Println("failed to render CLIPBOARD_TEMPLATE:", err)
+		clip = fn.URL
+	}
+	if cfg.OCRClipboard && text != "" {
+		clip += "\n\n" + text
+	}
+	if f.copied {
+		clip = ""
+	}
+
+	if !confirmed {
+		log.Println("not copying the URL of", f.Name)
+		return fn, nil
+	}
+
+	// a failed post doesn't fail the upload
+	if cfg.DiscordWebhook != "" {
+		err := postDiscord(cfg, fn, f.Name)
+		if err != nil {
+			log.Println("warning: failed to post to Discord:", err)
+		}
+	}
+
+	// the caller prints the URL itself
+	if Quiet {
+		return fn, nil
+	}
+
+	// quiet hours in notify mode only copy the URL
+	if silenced(cfg) {
+		if clip != "" {
+			copyToClipboard(clip)
+		}
+		return fn, nil
+	}
+
+	if cfg.QRCode != "" {
+		err := showQRCode(cfg, fn.URL)
+		if err != nil {
+			log.Println("warning: failed to show the QR code:", err)
+		}
+	}
+
+	// coalesce notifications of uploads close to each other
+	if batch != nil {
+		batch.Add(fn, f.Name, took, clip)
+		return fn, nil
+	}
+
+	// add url to clipboard
+	if clip != "" {
+		copyToClipboard(clip)
+	}
+
+	err = notify(cfg, fn, f.Name, took)
+	if err != nil {
+		return File{}, err
+	}
+	return fn, nil
+}
+
+// findDuplicate returns the content hash of a file and the URL it was
+// uploaded to before, the URL is empty if it wasn't. A checksum computed
+// before is used instead of reading the file again.
+func findDuplicate(cfg Config, f File) (hash, url string, err error) {
+	hash = f.sha256
+	if hash == "" {
+		hash, err = contentHash(f.Path)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	url, err = previousUpload(cfg, hash)
+	return hash, url, err
+}
+
+// generateHash will return a sha1 hash for a given filename
+func generateHash(str string) (hash string, err error) {
+	if str != "" {
+		h := sha1.New()
+		h.Write([]byte(str))
+		s := hex.EncodeToString(h.Sum(nil))
+		return s, nil
+	}
+	return "", errors.New("error generating hash")
+}
+
+// clipboardData is passed to the clipboard template
+type clipboardData struct {
+	Name string // Original name of the file
+	URL  string // URL of the uploaded file
+	Size int64  // Size of the uploaded file in bytes
+}
+
+// clipboardText returns the content for the clipboard, which is the URL
+// unless a name prefix or a template is configured
+func clipboardText(cfg Config, f File, originalName string) (string, error) {
+	if cfg.ClipboardTemplate == "" {
+		if cfg.ClipboardIncludeName {
+			return originalName + ": " + f.URL, nil
+		}
+		return f.URL, nil
+	}
+	t, err := template.New("clipboard").Parse(cfg.ClipboardTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, clipboardData{Name: originalName, URL: f.URL, Size: f.Size})
+	return buf.String(), err
+}
+
+// copyToClipboard writes text into the clipboard and logs failures
+func copyToClipboard(text string) {
+	err := SystemClipboard.WriteAll(text)
+	if err != nil {
+		log.Println("failed to write to clipboard:", err)
+	}
+}
+
+// checkClipboard warns if there is no clipboard backend available
+func checkClipboard() {
+	if _, ok := SystemClipboard.(osClipboard); ok && clipboard.Unsupported {
+		log.Println("warning: no clipboard utility found, URLs won't be copied. Install xclip, xsel or wl-clipboard (Wayland).")
+	}
+}
+
+// notify sends a notification about a finished upload
+func notify(cfg Config, f File, originalName string, took time.Duration) error {
+	return pushNotification(cfg, notificationData{
+		Name:     originalName,
+		URL:      f.URL,
+		Size:     f.Size,
+		Duration: took,
+		Count:    1,
+	})
+}
+
+// formatSize formats a size in bytes for humans
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
+
+// NotifyFailure sends a notification about a failed upload if enabled
+func NotifyFailure(cfg Config, f File, uploadErr error) {
+	if !cfg.NotifyOnFailure || silenced(cfg) {
+		return
+	}
+	err := pushNotification(cfg, notificationData{
+		Name:  f.Name,
+		Size:  f.Size,
+		Count: 1,
+		Err:   truncate(uploadErr.Error(), notifyErrLength),
+	})
+	if err != nil {
+		log.Println("failed to send failure notification:", err)
+	}
+}
+
+// Rename will rename and/or remove a file
+func rename(cfg Config, f File) (file File, err error) {
+	hash, err := newName(cfg, f)
+	if err != nil {
+		return File{}, err
+	}
+	fn := File{
+		Extension: f.Extension,
+		Name:      fmt.Sprintf("%s%s", hash, f.Extension),
+		RPath:     f.RPath,
+		RUrl:      f.RUrl,
+		Tags:      f.Tags,
+		Project:   f.Project,
+	}
+	if f.NameOverride != "" {
+		fn.Name = f.NameOverride
+		hash = strings.TrimSuffix(f.NameOverride, f.Extension)
+	}
+	fn.Name = nameCase(cfg, fn.Name)
+	fn.Extension = nameCase(cfg, fn.Extension)
+	hash = nameCase(cfg, hash)
+
+	// an archived or preserved file only gets a new name for the upload
+	if f.Archived || cfg.Preserve {
+		fn.Path = f.Path
+		return fn, nil
+	}
+
+	// the target of a symlink isn't ours, copy it instead of moving it
+	move := func(src, dst string) error {
+		return moveFile(cfg, src, dst)
+	}
+	if f.Symlink != "" {
+		move = copyLocal
+	}
+
+	// if we are not archiving a file just rename it into the processing
+	// directory, renaming it within the watch directory would trigger
+	// another upload if the new name matches the filter
+	if cfg.Archive == "" {
+		dir := processingDir(cfg)
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return File{}, err
+		}
+		err = checkFreeSpace(cfg, dir)
+		if err != nil {
+			return File{}, err
+		}
+		fn.Path = fmt.Sprintf("%s%s", filepath.Join(dir, hash), fn.Extension)
+		err = move(f.Path, fn.Path)
+		if err != nil {
+			return File{}, err
+		}
+	} else {
+		err = archiveDir(cfg)
+		if err != nil {
+			return File{}, err
+		}
+		dir := filepath.Join(cfg.Archive, archiveRoute(cfg, f))
+		err = os.MkdirAll(dir, cfg.ArchiveDirMode)
+		if err != nil {
+			return File{}, err
+		}
+		name, err := archiveName(cfg, f, hash, dir)
+		if err != nil {
+			return File{}, err
+		}
+		err = checkFreeSpace(cfg, dir)
+		if err != nil {
+			return File{}, err
+		}
+		fn.Path = filepath.Join(dir, name)
+		err = move(f.Path, fn.Path)
+		if err != nil {
+			return File{}, err
+		}
+		err = chmodArchived(cfg, fn.Path)
+		if err != nil {
+			return File{}, err
+		}
+	}
+	return fn, nil
+}
+
+// newName returns a new random name for a file without the extension.
+// Files with the same name created within the same second must not
+// collide, so the time, size and a few random bytes are hashed as well.
+// HashSalt keeps the name from being derived from that metadata.
+func newName(cfg Config, f File) (string, error) {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	hash, err := generateHash(fmt.Sprintf("%s:%s:%d:%d:%x", cfg.HashSalt, f.Name, time.Now().UnixNano(), fileSize(f.Path), random))
+	if err != nil {
+		return "", errors.New("error generating filename")
+	}
+	return hash, nil
+}
+
+// nameCase changes the case of a remote name according to NameCase
+func nameCase(cfg Config, name string) string {
+	switch cfg.NameCase {
+	case "lower":
+		return strings.ToLower(name)
+	case "upper":
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// processingDir returns the directory files are renamed into while they
+// are uploaded
+func processingDir(cfg Config) string {
+	if cfg.ProcessingDir != "" {
+		return cfg.ProcessingDir
+	}
+	return filepath.Join(cfg.LPath, ".screenupload")
+}
+
+// chmodArchived applies the configured mode to an archived file
+func chmodArchived(cfg Config, path string) error {
+	if cfg.ArchiveFileMode == 0 {
+		return nil
+	}
+	return os.Chmod(path, cfg.ArchiveFileMode)
+}
+
+// moveFile renames src to dst, transient failures are retried up to
+// RenameRetries times and a file on another file system is copied and
+// removed instead
+func moveFile(cfg Config, src, dst string) error {
+	for attempt := 0; ; attempt++ {
+		err := os.Rename(src, dst)
+		if err == nil {
+			return nil
+		}
+		if crossDevice(err) {
+			err = copyLocal(src, dst)
+			if err != nil {
+				os.Remove(dst)
+				return err
+			}
+			return os.Remove(src)
+		}
+		if attempt >= cfg.RenameRetries || !transientRename(err) {
+			return err
+		}
+		debugf("rename of %s failed, retrying: %v", src, err)
+		time.Sleep(time.Duration(attempt+1) * renameRetryDelay)
+	}
+}
+
+// copyLocal copies the file src to dst, on the same APFS volume it is
+// cloned instead of copying its data
+func copyLocal(src, dst string) error {
+	if cloneFile(src, dst) == nil {
+		return nil
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Trash removes a given file
+func trash(cfg Config, f File) error {
+	err := os.Remove(f.Path)
+	if err != nil {
+		return err
+	}
+	return nil
+}
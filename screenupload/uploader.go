@@ -0,0 +1,179 @@
+package screenupload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Uploader uploads files to a destination
+type Uploader interface {
+	// Upload uploads a renamed file
+	Upload(f File) error
+	// URL returns the URL where an uploaded file is accessible
+	URL(f File) string
+}
+
+// ReaderUploader is implemented by uploaders which can upload content which
+// isn't in a file on disk
+type ReaderUploader interface {
+	// UploadReader uploads size bytes read from r under the name and remote
+	// path of f, f.Path is ignored
+	UploadReader(r io.Reader, size int64, f File) error
+}
+
+// LocationUploader is implemented by uploaders which can learn the URL of an
+// upload from the server, e.g. because it stores files under another name
+type LocationUploader interface {
+	// UploadLocation uploads a renamed file like Upload and returns its URL
+	// as reported by the server, it is empty if the server didn't report one
+	UploadLocation(f File) (string, error)
+}
+
+// Remover is implemented by uploaders which can delete uploaded files
+type Remover interface {
+	// Remove deletes an uploaded file from the remote side
+	Remove(f File) error
+}
+
+// errWritableUnsupported is returned by CheckWritable of an uploader which
+// implements WritableChecker but can't check any of its backends
+var errWritableUnsupported = errors.New("checking the remote path is not supported")
+
+// WritableChecker is implemented by uploaders which can check that they are
+// allowed to write to the remote path before the first upload
+type WritableChecker interface {
+	// CheckWritable returns an error if files can't be written remotely
+	CheckWritable() error
+}
+
+// Connector is implemented by uploaders which keep a persistent connection
+// to the server, the watcher holds new files while it is down
+type Connector interface {
+	// Connected returns a channel which is closed while the uploader is
+	// connected
+	Connected() <-chan struct{}
+}
+
+// NewUploader returns the Uploader for the configured backend, with
+// Backends it uploads to all of them
+func NewUploader(cfg Config) (Uploader, error) {
+	if len(cfg.Backends) > 0 {
+		return NewMultiUploader(cfg)
+	}
+	switch cfg.Backend {
+	case "", "scp":
+		switch cfg.Protocol {
+		case "auto", "scp", "sftp":
+		default:
+			return nil, fmt.Errorf("unknown protocol %q", cfg.Protocol)
+		}
+		if cfg.RUrl == "" {
+			return nil, errors.New("scp backend requires RURL")
+		}
+		return NewSCPUploader(cfg), nil
+	case "file":
+		if cfg.FileDest == "" {
+			return nil, errors.New("file backend requires FILE_DEST")
+		}
+		return NewFileUploader(cfg), nil
+	case "git":
+		if cfg.GitRepo == "" {
+			return nil, errors.New("git backend requires GIT_REPO")
+		}
+		if cfg.RUrl == "" && cfg.GitURLTemplate == "" {
+			return nil, errors.New("git backend requires RURL or GIT_URL_TEMPLATE")
+		}
+		return NewGitUploader(cfg)
+	case "webdav":
+		if cfg.WebDAVURL == "" {
+			return nil, errors.New("webdav backend requires WEBDAV_URL")
+		}
+		if cfg.RUrl == "" {
+			return nil, errors.New("webdav backend requires RURL")
+		}
+		switch cfg.WebDAVAuth {
+		case "basic", "digest":
+		default:
+			return nil, fmt.Errorf("unknown WEBDAV_AUTH %q", cfg.WebDAVAuth)
+		}
+		return NewWebDAVUploader(cfg)
+	case "b2":
+		if cfg.B2KeyID == "" || cfg.B2ApplicationKey == "" || cfg.B2Bucket == "" {
+			return nil, errors.New("b2 backend requires B2_KEY_ID, B2_APPLICATION_KEY and B2_BUCKET")
+		}
+		return NewB2Uploader(cfg)
+	}
+	return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+}
+
+// remotePath returns the remote directory of a file, it can be overridden
+// per file. Files which weren't found by NewFile have no project.
+func remotePath(cfg Config, f File) string {
+	if f.RPath != "" {
+		return f.RPath
+	}
+	return expandProject(cfg.RPath, "")
+}
+
+// remoteURL returns the base URL of a file, it can be overridden per file
+func remoteURL(cfg Config, f File) string {
+	if f.RUrl != "" {
+		return f.RUrl
+	}
+	return expandProject(cfg.RUrl, "")
+}
+
+// FileUploader "uploads" files by copying them into a local directory, it is
+// useful for testing without a remote server
+type FileUploader struct {
+	cfg Config
+}
+
+// NewFileUploader returns a FileUploader copying into FileDest
+func NewFileUploader(cfg Config) *FileUploader {
+	return &FileUploader{cfg: cfg}
+}
+
+// Upload copies a file into the destination directory
+func (u *FileUploader) Upload(f File) error {
+	dst := filepath.Join(u.cfg.FileDest, f.Name)
+	err := copyLocal(f.Path, dst)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dst, u.cfg.RemoteFileMode)
+}
+
+// Remove deletes a file from the destination directory
+func (u *FileUploader) Remove(f File) error {
+	return os.Remove(filepath.Join(u.cfg.FileDest, f.Name))
+}
+
+// Missing returns the files which don't exist in the destination directory
+func (u *FileUploader) Missing(files []File) ([]File, error) {
+	var missing []File
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(u.cfg.FileDest, f.Name))
+		if os.IsNotExist(err) {
+			missing = append(missing, f)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// URL returns the URL of an uploaded file, it is a file:// URL if no remote
+// URL is configured
+func (u *FileUploader) URL(f File) string {
+	if remoteURL(u.cfg, f) == "" {
+		abs, err := filepath.Abs(filepath.Join(u.cfg.FileDest, f.Name))
+		if err == nil {
+			return "file://" + filepath.ToSlash(abs)
+		}
+	}
+	return fmt.Sprintf("%s/%s", remoteURL(u.cfg, f), f.Name)
+}
@@ -0,0 +1,51 @@
+package screenupload
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewUploaderRequiresRURL(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config func(c *Config)
+		err    string // empty if the config is valid
+	}{
+		{"scp", func(c *Config) {}, "scp backend requires RURL"},
+		{"scp with RURL", func(c *Config) { c.RUrl = "https://example.com/u" }, ""},
+		{"git", func(c *Config) {
+			c.Backend = "git"
+			c.GitRepo = "/srv/screenshots"
+		}, "git backend requires RURL or GIT_URL_TEMPLATE"},
+		{"git with RURL", func(c *Config) {
+			c.Backend = "git"
+			c.GitRepo = "/srv/screenshots"
+			c.RUrl = "https://example.com/u"
+		}, ""},
+		{"git with GIT_URL_TEMPLATE", func(c *Config) {
+			c.Backend = "git"
+			c.GitRepo = "/srv/screenshots"
+			c.GitURLTemplate = "https://example.com/{{.Name}}"
+		}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RUrl = ""
+			tc.config(&cfg)
+			u, err := NewUploader(cfg)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("NewUploader = %v", err)
+				}
+				if c, ok := u.(io.Closer); ok {
+					c.Close()
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("NewUploader = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package screenupload
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// Verifier is implemented by uploaders which can check whether uploaded
+// files still exist on the remote side
+type Verifier interface {
+	// Missing returns the files which don't exist on the remote side
+	Missing(files []File) ([]File, error)
+}
+
+// VerifyArchive checks whether the files in the archive still exist on the
+// remote side and returns the missing ones. Archived files are looked up by
+// their name in the archive, which is the uploaded name unless
+// ArchiveNameTemplate is set.
+func VerifyArchive(cfg Config, u Uploader) ([]File, error) {
+	if cfg.Archive == "" {
+		return nil, errors.New("verifying remote files requires ARCHIVE")
+	}
+	if cfg.ArchiveNameTemplate != "" {
+		return nil, errors.New("archived files can't be verified with ARCHIVE_NAME_TEMPLATE, their names differ from the uploaded ones")
+	}
+	v, ok := u.(Verifier)
+	if !ok {
+		return nil, errors.New("the backend can't check remote files")
+	}
+
+	archived, err := archivedFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, a := range archived {
+		files = append(files, File{
+			Path:      a.Path,
+			Extension: filepath.Ext(a.Path),
+			Name:      a.Info.Name(),
+			Size:      a.Info.Size(),
+			Archived:  true,
+		})
+	}
+	return v.Missing(files)
+}
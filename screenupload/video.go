@@ -0,0 +1,67 @@
+package screenupload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// isVideo reports whether a file is a screen recording by its extension
+func isVideo(cfg Config, f File) bool {
+	for _, ext := range cfg.VideoExtensions {
+		if ext != "" && strings.EqualFold(strings.TrimSpace(ext), f.Extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// processed reports whether a file goes through the image oriented steps
+// like conversion and OCR
+func processed(cfg Config, f File) bool {
+	return !cfg.VideoSkipProcessing || !isVideo(cfg, f)
+}
+
+// checkVideo checks that VideoRPath and VideoRUrl are set together
+func checkVideo(cfg Config) error {
+	if (cfg.VideoRPath == "") != (cfg.VideoRUrl == "") {
+		return errors.New("VIDEO_RPATH and VIDEO_RURL have to be set together")
+	}
+	return nil
+}
+
+// transcodeVideo transcodes a video to MP4 with ffmpeg into a temporary file
+// and returns it with the new extension. MP4 files are returned unchanged.
+func transcodeVideo(cfg Config, f File) (File, error) {
+	if strings.EqualFold(f.Extension, ".mp4") {
+		return f, nil
+	}
+
+	tmp, err := createTemp(cfg, "screenupload-*.mp4")
+	if err != nil {
+		return File{}, err
+	}
+	tmp.Close()
+
+	args := append([]string{"-nostdin", "-y", "-loglevel", "error", "-i", f.Path}, cfg.VideoTranscodeArgs...)
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", append(args, tmp.Name())...)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		removeTemp(tmp.Name())
+		return File{}, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	before, after := fileSize(f.Path), fileSize(tmp.Name())
+	log.Printf("transcoded %s to mp4, %s -> %s", f.Name, formatSize(before), formatSize(after))
+
+	transcoded := f
+	transcoded.Path = tmp.Name()
+	transcoded.Extension = ".mp4"
+	transcoded.Name = strings.TrimSuffix(f.Name, f.Extension) + ".mp4"
+	return transcoded, nil
+}
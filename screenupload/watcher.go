@@ -0,0 +1,740 @@
+package screenupload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// eventBuffer is the number of upload events buffered for a slow consumer
+const eventBuffer = 16
+
+// emptyRecheckDelay is how long to wait before checking an empty file again
+const emptyRecheckDelay = time.Second
+
+// lockedRetryDelay is how long to wait before uploading a file again which
+// another process has open, it is tried lockedRetries times
+const (
+	lockedRetryDelay = 2 * time.Second
+	lockedRetries    = 5
+)
+
+// rewriteSettleDelay is how long a file rewritten in place has to stay
+// unchanged before it is handled, see UploadCooldown
+const rewriteSettleDelay = time.Second
+
+// fileVersion tells apart versions of a file rewritten in place
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// UploadEvent is the outcome of an upload
+type UploadEvent struct {
+	File File   // File as it was found in the watch directory
+	URL  string // URL of the uploaded file, empty if the upload failed
+	Err  error  // Error of a failed upload
+}
+
+// Watcher uploads new files created in the watch directory
+type Watcher struct {
+	cfg      Config
+	u        Uploader
+	filter   *regexp.Regexp
+	excludes []*regexp.Regexp
+	batch    *batcher   // collects notifications if batching is enabled
+	anim     *animator  // assembles animations if GIFFilter is set
+	schedule schedule   // next upload of queued files if UploadSchedule is set
+	quiet    quietHours // holds new files during QuietHours in queue mode
+
+	events        chan UploadEvent // nil unless Events was called
+	pause, resume chan struct{}
+	stop          chan struct{}
+	stopOnce      sync.Once
+	ready         chan struct{} // closed once the watch directory is watched
+}
+
+// NewWatcher checks the options of the watcher and returns a Watcher
+// uploading with u
+func NewWatcher(cfg Config, u Uploader) (*Watcher, error) {
+	filter, err := regexp.Compile(cfg.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FILTER: %v", err)
+	}
+	excludes, err := compileExcludes(cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range []string{cfg.MinDimensions, cfg.MaxDimensions} {
+		if _, _, err := parseDimensions(d); d != "" && err != nil {
+			return nil, err
+		}
+	}
+
+	w := &Watcher{
+		cfg:      cfg,
+		u:        u,
+		filter:   filter,
+		excludes: excludes,
+		pause:    make(chan struct{}, 1),
+		resume:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		ready:    make(chan struct{}),
+	}
+	if cfg.NotifyBatch {
+		w.batch = newBatcher(cfg)
+	}
+	if cfg.UploadSchedule != "" {
+		w.schedule, err = parseSchedule(cfg.UploadSchedule)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := checkQuietHours(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.BreakerFailures > 0 && cfg.BreakerProbe <= 0 {
+		return nil, fmt.Errorf("BREAKER_PROBE has to be positive with BREAKER_FAILURES")
+	}
+	if cfg.QuietHours != "" && cfg.QuietHoursMode != "notify" {
+		w.quiet, _ = parseQuietHours(cfg.QuietHours)
+	}
+	if cfg.GIFFilter != "" {
+		frames, err := regexp.Compile(cfg.GIFFilter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GIF_FILTER: %v", err)
+		}
+		w.anim = newAnimator(cfg, frames, w.stop)
+	}
+	return w, nil
+}
+
+// Start watches the watch directory and uploads new files until ctx is
+// done or Stop is called. With SingleInstance the watch directory is locked
+// first, then it waits for the network as configured by WaitForNetwork.
+// Failed uploads are logged, reported and notified, they don't stop the
+// watcher. With ProcessExisting the files in the watch directory are
+// handled like new ones. Temporary files are removed and the uploader is
+// closed if it is an io.Closer before it returns.
+func (w *Watcher) Start(ctx context.Context) error {
+	cfg := w.cfg
+	follow := cfg.FollowScreenshotLocation && runtime.GOOS == "darwin"
+	if follow {
+		if dir, err := macScreenshotDir(); err == nil && dir != "" && dir != cfg.LPath {
+			log.Println("watching the screenshot location", dir, "instead of", cfg.LPath)
+			cfg.LPath = dir
+		}
+	}
+	// a second instance fails before it waits for anything
+	release := func() {}
+	if cfg.SingleInstance {
+		var err error
+		release, err = lockInstance(cfg.LPath)
+		if err != nil {
+			return err
+		}
+	}
+	defer func() { release() }()
+
+	err := WaitForNetwork(cfg)
+	if err != nil {
+		log.Println("warning:", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	defer CleanupTempFiles()
+	// animations assembled from now on are dropped
+	defer w.Stop()
+	// the persistent connection of the uploader isn't needed anymore
+	if c, ok := w.u.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	err = watcher.Add(cfg.LPath)
+	if err != nil {
+		return err
+	}
+	close(w.ready)
+
+	var animations <-chan File
+	if w.anim != nil {
+		animations = w.anim.Ready()
+	}
+
+	// files which are there already are handled like new ones
+	var existing <-chan scanned
+	if cfg.ProcessExisting {
+		paths, err := existingFiles(cfg, func(name string) bool {
+			if w.anim != nil && w.anim.Match(filepath.Join(cfg.LPath, name)) {
+				return false
+			}
+			return w.filter.MatchString(name) && !excluded(w.excludes, name)
+		})
+		if err != nil {
+			log.Println("failed to scan the watch directory:", err)
+		} else {
+			log.Printf("found %d existing files in %s", len(paths), cfg.LPath)
+			// the scan runs at most ScanQueue files ahead of the uploads
+			c := make(chan scanned, max(cfg.ScanQueue, 0))
+			go scanExisting(cfg, paths, c, w.stop)
+			existing = c
+		}
+	}
+
+	var relocate <-chan time.Time
+	if follow {
+		t := time.NewTicker(screenshotLocationInterval)
+		defer t.Stop()
+		relocate = t.C
+	}
+
+	// queued files are uploaded when the timer fires
+	var (
+		flushTimer *time.Timer
+		flush      <-chan time.Time
+	)
+	if w.schedule != nil {
+		next := w.schedule(time.Now())
+		log.Println("uploading new files at", next.Format("2006-01-02 15:04:05"))
+		flushTimer = time.NewTimer(time.Until(next))
+		defer flushTimer.Stop()
+		flush = flushTimer.C
+	}
+
+	var (
+		paused   bool
+		pending  []File
+		queued   []File // files waiting for the next scheduled upload
+		rechecks = make(chan string)
+		locked   = make(map[string]int)         // retries of files in use
+		lastSeen = make(map[string]time.Time)   // last upload of a path for UploadCooldown
+		cooling  = make(map[string]bool)        // paths uploaded again once their cooldown ends
+		uploaded = make(map[string]fileVersion) // version of a path when it was uploaded for UploadCooldown
+		rewrites = make(map[string]*time.Timer) // settle timers of files rewritten in place
+		settled  = make(chan string)
+		held     []File // files held until the quiet hours end
+		quietEnd <-chan time.Time
+
+		offline     []File // files held while the uploader is disconnected
+		reconnected <-chan struct{}
+
+		prehashed = make(map[string]scanned) // checksums of the startup scan
+
+		cb     = breaker{max: cfg.BreakerFailures}
+		broken []File // files held while the circuit breaker is open
+		probe  <-chan time.Time
+	)
+
+	// hold keeps files in the watch directory until the quiet hours end, it
+	// reports false outside of quiet hours
+	hold := func(files ...File) bool {
+		if w.quiet == nil {
+			return false
+		}
+		quiet, end := w.quiet(time.Now())
+		if !quiet {
+			return false
+		}
+		for _, f := range files {
+			log.Println("quiet hours, holding", f.Path, "until", end.Format("15:04"))
+		}
+		held = append(held, files...)
+		if quietEnd == nil {
+			quietEnd = time.After(time.Until(end))
+		}
+		return true
+	}
+
+	// disconnected holds a file while the persistent connection of the
+	// uploader is down, it reports false if the uploader is connected
+	conn, _ := w.u.(Connector)
+	disconnected := func(f File) bool {
+		if conn == nil {
+			return false
+		}
+		up := conn.Connected()
+		select {
+		case <-up:
+			return false
+		default:
+		}
+		log.Println("not connected, holding", f.Path, "until the connection is back")
+		offline = append(offline, f)
+		reconnected = up
+		return true
+	}
+
+	// record counts the outcome of an upload for the circuit breaker, local
+	// failures say nothing about the server and are left out
+	record := func(err error) {
+		if err != nil && !remoteFailure(err) {
+			return
+		}
+		if cb.Record(err) {
+			log.Printf("circuit breaker: %d uploads failed in a row, holding new files and probing the server every %s", cfg.BreakerFailures, cfg.BreakerProbe)
+			probe = time.After(cfg.BreakerProbe)
+		}
+	}
+
+	// tripped holds a file while the circuit breaker is open, it reports
+	// false if uploads go on
+	tripped := func(f File) bool {
+		if !cb.Open() {
+			return false
+		}
+		log.Println("circuit breaker open, holding", f.Path)
+		broken = append(broken, f)
+		return true
+	}
+
+	// later handles a file again after a delay
+	later := func(path string, delay time.Duration) {
+		time.AfterFunc(delay, func() {
+			select {
+			case rechecks <- path:
+			case <-w.stop:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	// send uploads a file and reports the outcome, a failure is logged and
+	// the watcher carries on with the next file
+	send := func(f File) {
+		if tripped(f) || disconnected(f) {
+			return
+		}
+		fn, err := upload(cfg, w.u, f, w.batch)
+		record(err)
+		w.finish(f, fn, err)
+	}
+
+	// gate keeps a new file back while uploads are paused, during quiet
+	// hours, until the next scheduled upload, while the circuit breaker is
+	// open or the uploader is disconnected. It reports false if the file
+	// can be uploaded now.
+	gate := func(f File) bool {
+		if paused {
+			if cfg.PauseMode == "ignore" {
+				log.Println("paused, ignoring", f.Path)
+				return true
+			}
+			log.Println("paused, buffering", f.Path)
+			pending = append(pending, f)
+			return true
+		}
+		if hold(f) {
+			return true
+		}
+		if w.schedule != nil {
+			queued = append(queued, w.queue(f))
+			return true
+		}
+		return tripped(f) || disconnected(f)
+	}
+
+	// handle uploads a new file. Screenshot tools may create an empty file
+	// before writing into it, so empty files are checked again after a delay.
+	// Files which are still open in another process can't be moved on
+	// windows, their upload is retried a few times.
+	handle := func(path string, recheck bool) {
+		f, ok := NewFile(cfg, path)
+		if !ok || !allowed(cfg, f) {
+			return
+		}
+		// the checksum of the startup scan is used unless the file changed
+		if s, ok := prehashed[path]; ok {
+			delete(prehashed, path)
+			if info, err := os.Stat(f.Path); err == nil && (fileVersion{info.ModTime(), info.Size()}) == s.version {
+				f.sha256 = s.sha256
+			}
+		}
+		if !cfg.AllowEmpty && fileSize(f.Path) == 0 {
+			if recheck {
+				log.Println("skipping empty file", f.Path)
+				return
+			}
+			later(path, emptyRecheckDelay)
+			return
+		}
+		// a path rewritten over and over is uploaded once per cooldown, the
+		// latest version once it ends. Retries of the same file aren't
+		// rewrites, a version which was uploaded already is skipped.
+		var version fileVersion
+		if cfg.UploadCooldown > 0 {
+			if info, err := os.Stat(f.Path); err == nil {
+				version = fileVersion{info.ModTime(), info.Size()}
+				if v, ok := uploaded[path]; ok && v == version {
+					debugf("skipping %s, it didn't change since its upload", f.Path)
+					return
+				}
+			}
+			now := time.Now()
+			if last, ok := lastSeen[path]; ok && !recheck && now.Sub(last) < cfg.UploadCooldown {
+				if !cooling[path] {
+					wait := cfg.UploadCooldown - now.Sub(last)
+					log.Printf("%s was uploaded %s ago, uploading it again in %s", f.Path, now.Sub(last).Round(time.Second), wait.Round(time.Second))
+					cooling[path] = true
+					later(path, wait)
+				}
+				return
+			}
+			delete(cooling, path)
+			for p, t := range lastSeen {
+				if now.Sub(t) >= cfg.UploadCooldown {
+					delete(lastSeen, p)
+					delete(uploaded, p)
+				}
+			}
+			lastSeen[path] = now
+		}
+		if gate(f) {
+			return
+		}
+		fn, err := upload(cfg, w.u, f, w.batch)
+		if isLocked(err) && locked[path] < lockedRetries {
+			locked[path]++
+			log.Println(f.Path, "is in use by another process, retrying in", lockedRetryDelay)
+			later(path, lockedRetryDelay)
+			return
+		}
+		delete(locked, path)
+		record(err)
+		if err == nil && cfg.UploadCooldown > 0 {
+			uploaded[path] = version
+		}
+		w.finish(f, fn, err)
+	}
+
+	// rewritten handles a file rewritten in place once it wasn't written
+	// for rewriteSettleDelay
+	rewritten := func(path string) {
+		if t, ok := rewrites[path]; ok {
+			t.Reset(rewriteSettleDelay)
+			return
+		}
+		rewrites[path] = time.AfterFunc(rewriteSettleDelay, func() {
+			select {
+			case settled <- path:
+			case <-w.stop:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.stop:
+			return nil
+		case event := <-watcher.Events:
+			// writes into an existing file only matter for the cooldown
+			create := event.Has(fsnotify.Create)
+			rewrite := !create && event.Has(fsnotify.Write) && cfg.UploadCooldown > 0
+			if !create && !rewrite {
+				continue
+			}
+			name := filepath.Base(event.Name)
+			// directories are never uploaded, subdirectories aren't watched
+			if isDir(event.Name) {
+				debugf("skipping directory %s", event.Name)
+				continue
+			}
+			// frames of an animation are collected instead of uploaded
+			if w.anim != nil && w.anim.Match(event.Name) && !excluded(w.excludes, name) {
+				if create {
+					w.anim.Add(event.Name)
+				}
+				continue
+			}
+			if !w.filter.MatchString(name) || excluded(w.excludes, name) {
+				continue
+			}
+			if rewrite {
+				rewritten(event.Name)
+				continue
+			}
+			handle(event.Name, false)
+		case s, ok := <-existing:
+			if !ok {
+				existing = nil
+				continue
+			}
+			// a new file may have been uploaded before the scan got to it
+			if _, err := os.Stat(s.path); err != nil {
+				continue
+			}
+			if s.sha256 != "" {
+				prehashed[s.path] = s
+			}
+			handle(s.path, false)
+		case path := <-rechecks:
+			handle(path, true)
+		case path := <-settled:
+			delete(rewrites, path)
+			// uploaded files are moved out of the watch directory
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			handle(path, false)
+		case anim := <-animations:
+			f, ok := NewFile(cfg, anim.Path)
+			if !ok || !allowed(cfg, f) {
+				continue
+			}
+			f.frames = anim.frames
+			if gate(f) {
+				continue
+			}
+			send(f)
+		case err := <-watcher.Errors:
+			log.Println("error:", err)
+		case <-w.pause:
+			if !paused {
+				log.Println("paused uploads")
+				paused = true
+			}
+		case <-w.resume:
+			if !paused {
+				continue
+			}
+			log.Printf("resumed uploads, flushing %d buffered files", len(pending))
+			paused = false
+			if hold(pending...) {
+				pending = nil
+				continue
+			}
+			for _, f := range pending {
+				send(f)
+			}
+			pending = nil
+		case <-flush:
+			// a scheduled upload during quiet hours waits for the next one
+			// after them
+			if len(queued) > 0 && hold(queued...) {
+				queued = nil
+				flushTimer.Reset(time.Until(w.schedule(time.Now())))
+				continue
+			}
+			if len(queued) > 0 {
+				log.Printf("uploading %d queued files", len(queued))
+			}
+			for _, f := range queued {
+				// the file may have been removed while it was queued
+				if _, err := os.Stat(f.Path); err != nil {
+					log.Println("skipping queued file:", err)
+					continue
+				}
+				send(f)
+			}
+			queued = nil
+			flushTimer.Reset(time.Until(w.schedule(time.Now())))
+		case <-quietEnd:
+			quietEnd = nil
+			files := held
+			held = nil
+			// another range of quiet hours may follow right away
+			if hold(files...) {
+				continue
+			}
+			log.Printf("quiet hours ended, uploading %d held files", len(files))
+			for _, f := range files {
+				if _, err := os.Stat(f.Path); err != nil {
+					log.Println("skipping held file:", err)
+					continue
+				}
+				if w.schedule != nil {
+					if !f.copied {
+						f = w.queue(f)
+					}
+					queued = append(queued, f)
+					continue
+				}
+				send(f)
+			}
+		case <-reconnected:
+			reconnected = nil
+			files := offline
+			offline = nil
+			log.Printf("connection is back, uploading %d held files", len(files))
+			for _, f := range files {
+				if _, err := os.Stat(f.Path); err != nil {
+					log.Println("skipping held file:", err)
+					continue
+				}
+				send(f)
+			}
+		case <-probe:
+			probe = nil
+			cb.HalfOpen()
+			// the server is probed without an upload if the uploader can,
+			// otherwise with the first held file or the next new one
+			probed := false
+			if wc, ok := w.u.(WritableChecker); ok {
+				err := wc.CheckWritable()
+				if err != errWritableUnsupported {
+					if err != nil {
+						log.Println("circuit breaker: probe failed:", err)
+						err = &uploadError{err}
+					}
+					record(err)
+					probed = true
+				}
+			}
+			if !probed && len(broken) > 0 {
+				f := broken[0]
+				broken = broken[1:]
+				if _, err := os.Stat(f.Path); err == nil {
+					send(f)
+				}
+			}
+			if cb.Open() {
+				continue
+			}
+			files := broken
+			broken = nil
+			if len(files) > 0 {
+				log.Printf("circuit breaker closed, uploading %d held files", len(files))
+			}
+			for _, f := range files {
+				if _, err := os.Stat(f.Path); err != nil {
+					log.Println("skipping held file:", err)
+					continue
+				}
+				send(f)
+			}
+		case <-relocate:
+			dir, err := macScreenshotDir()
+			if err != nil || dir == "" || dir == cfg.LPath {
+				continue
+			}
+			// the lock moves along, another instance may watch the new
+			// location already
+			relock := func() {}
+			if cfg.SingleInstance {
+				relock, err = lockInstance(dir)
+				if err != nil {
+					log.Println("not switching to the new screenshot location:", err)
+					continue
+				}
+			}
+			err = watcher.Add(dir)
+			if err != nil {
+				relock()
+				log.Println("failed to watch the new screenshot location:", err)
+				continue
+			}
+			watcher.Remove(cfg.LPath)
+			release()
+			release = relock
+			log.Println("screenshot location changed, watching", dir, "instead of", cfg.LPath)
+			cfg.LPath = dir
+		}
+	}
+}
+
+// queue prepares a file for the next scheduled upload. Unless
+// ScheduleClipboard is upload, its name is chosen now and the URL copied to
+// the clipboard right away.
+func (w *Watcher) queue(f File) File {
+	log.Println("queued", f.Path, "for the next scheduled upload")
+	if w.cfg.ScheduleClipboard == "upload" {
+		return f
+	}
+	if f.NameOverride == "" {
+		hash, err := newName(w.cfg, f)
+		if err != nil {
+			log.Println("warning: failed to choose the name of", f.Path, err)
+			return f
+		}
+		f.NameOverride = hash + f.Extension
+	}
+	fn := f
+	fn.Name = nameCase(w.cfg, f.NameOverride)
+	fn.URL = w.u.URL(fn)
+	clip, err := clipboardText(w.cfg, fn, f.Name)
+	if err != nil {
+		log.Println("failed to render CLIPBOARD_TEMPLATE:", err)
+		clip = fn.URL
+	}
+	copyToClipboard(clip)
+	f.copied = true
+	return f
+}
+
+// Events returns a channel receiving the outcome of every upload, it has to
+// be called before Start. The channel is buffered, events are dropped with
+// a warning instead of blocking uploads if the consumer falls behind. It is
+// never closed.
+func (w *Watcher) Events() <-chan UploadEvent {
+	if w.events == nil {
+		w.events = make(chan UploadEvent, eventBuffer)
+	}
+	return w.events
+}
+
+// finish reports the outcome of an upload, a failure is logged and notified
+func (w *Watcher) finish(f, fn File, err error) {
+	w.report(UploadEvent{File: f, URL: fn.URL, Err: err})
+	if err != nil {
+		log.Printf("failed to upload %s: %s", f.Path, Failure(err))
+		NotifyFailure(w.cfg, f, err)
+	}
+}
+
+// report sends an event to the consumer of Events without blocking
+func (w *Watcher) report(ev UploadEvent) {
+	if w.events == nil {
+		return
+	}
+	select {
+	case w.events <- ev:
+	default:
+		log.Println("warning: events channel is full, dropping the event of", ev.File.Name)
+	}
+}
+
+// Ready returns a channel which is closed once Start watches the watch
+// directory
+func (w *Watcher) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// Stop stops a running Watcher, it can be called from any goroutine. The
+// uploader is closed once the current upload finished.
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+// Pause pauses uploads, new files are buffered or ignored depending on
+// PauseMode
+func (w *Watcher) Pause() {
+	select {
+	case w.pause <- struct{}{}:
+	default:
+	}
+}
+
+// Resume resumes uploads and uploads the buffered files
+func (w *Watcher) Resume() {
+	select {
+	case w.resume <- struct{}{}:
+	default:
+	}
+}
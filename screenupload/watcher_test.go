@@ -0,0 +1,512 @@
+package screenupload_test
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dewey/go-screenupload/screenupload"
+	"github.com/dewey/go-screenupload/screenupload/screenuploadtest"
+)
+
+// fakeUploader records uploads, Fail decides which of them fail
+type fakeUploader struct {
+	Fail func(f screenupload.File) error
+
+	mu       sync.Mutex
+	uploaded []string
+}
+
+func (u *fakeUploader) Upload(f screenupload.File) error {
+	if u.Fail != nil {
+		if err := u.Fail(f); err != nil {
+			return err
+		}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.uploaded = append(u.uploaded, f.Name)
+	return nil
+}
+
+func (u *fakeUploader) URL(f screenupload.File) string {
+	return "https://example.com/" + f.Name
+}
+
+// Uploaded returns the names of the uploaded files, oldest first
+func (u *fakeUploader) Uploaded() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.uploaded...)
+}
+
+// testWatcherConfig returns a config watching a new temporary directory
+func testWatcherConfig(t *testing.T) screenupload.Config {
+	cfg := screenupload.DefaultConfig()
+	cfg.LPath = t.TempDir()
+	cfg.TempDir = t.TempDir()
+	cfg.Filter = `^shot-.*\.png$`
+	cfg.SingleInstance = false
+	return cfg
+}
+
+// startWatcher runs w until the test ends and waits until it watches
+func startWatcher(t *testing.T, w *screenupload.Watcher) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Start(context.Background())
+	}()
+	t.Cleanup(func() {
+		w.Stop()
+		if err := <-done; err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	})
+	select {
+	case <-w.Ready():
+	case err := <-done:
+		t.Fatalf("Start: %v", err)
+	}
+}
+
+// nextEvent waits for the next upload event
+func nextEvent(t *testing.T, events <-chan screenupload.UploadEvent) screenupload.UploadEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an upload")
+	}
+	return screenupload.UploadEvent{}
+}
+
+// writeShot moves a new file into the watch directory, it isn't empty when
+// the watcher sees it
+func writeShot(t *testing.T, cfg screenupload.Config, name string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(p, []byte(name), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(p, filepath.Join(cfg.LPath, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherContinuesAfterFailure(t *testing.T) {
+	notifier, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := testWatcherConfig(t)
+	var calls int
+	u := &fakeUploader{Fail: func(f screenupload.File) error {
+		calls++
+		if calls == 1 {
+			return errors.New("server on fire")
+		}
+		return nil
+	}}
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	writeShot(t, cfg, "shot-1.png")
+	if ev := nextEvent(t, events); ev.Err == nil {
+		t.Fatalf("upload of %s didn't fail", ev.File.Name)
+	}
+	writeShot(t, cfg, "shot-2.png")
+	ev := nextEvent(t, events)
+	if ev.Err != nil || ev.File.Name != "shot-2.png" {
+		t.Fatalf("upload after a failure: %s %v", ev.File.Name, ev.Err)
+	}
+	if len(u.Uploaded()) != 1 {
+		t.Errorf("uploaded %v, want one file", u.Uploaded())
+	}
+	if len(notifier.Notifications()) == 0 {
+		t.Error("the failure wasn't notified")
+	}
+}
+
+// connectorUploader is a fakeUploader with a connection which is up once
+// Connect is called
+type connectorUploader struct {
+	fakeUploader
+	up chan struct{}
+}
+
+func (u *connectorUploader) Connected() <-chan struct{} {
+	return u.up
+}
+
+func (u *connectorUploader) Connect() {
+	close(u.up)
+}
+
+func TestWatcherHoldsFilesWhileDisconnected(t *testing.T) {
+	_, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := testWatcherConfig(t)
+	u := &connectorUploader{up: make(chan struct{})}
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	writeShot(t, cfg, "shot-1.png")
+	writeShot(t, cfg, "shot-2.png")
+	select {
+	case ev := <-events:
+		t.Fatalf("%s was uploaded while disconnected", ev.File.Name)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	u.Connect()
+	for i := 0; i < 2; i++ {
+		if ev := nextEvent(t, events); ev.Err != nil {
+			t.Fatalf("upload of %s failed: %v", ev.File.Name, ev.Err)
+		}
+	}
+	if got := u.Uploaded(); len(got) != 2 {
+		t.Errorf("uploaded %v, want both files", got)
+	}
+}
+
+// writeFrame moves a new PNG image into the watch directory
+func writeFrame(t *testing.T, cfg screenupload.Config, name string, c color.Color) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(1, 1, c)
+	p := filepath.Join(t.TempDir(), name)
+	w, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(w, img)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(p, filepath.Join(cfg.LPath, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherAnimationIsGated(t *testing.T) {
+	_, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := testWatcherConfig(t)
+	cfg.GIFFilter = `^frame-.*\.png$`
+	cfg.GIFWindow = 50 * time.Millisecond
+	cfg.Archive = t.TempDir()
+	u := &fakeUploader{}
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	w.Pause()
+	writeFrame(t, cfg, "frame-1.png", color.Black)
+	writeFrame(t, cfg, "frame-2.png", color.White)
+	select {
+	case ev := <-events:
+		t.Fatalf("%s was uploaded while paused", ev.File.Name)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	w.Resume()
+	ev := nextEvent(t, events)
+	if ev.Err != nil {
+		t.Fatal(ev.Err)
+	}
+	if filepath.Ext(ev.URL) != ".gif" {
+		t.Errorf("uploaded %s, want the gif", ev.URL)
+	}
+	for _, name := range []string{"frame-1.png", "frame-2.png"} {
+		if _, err := os.Stat(filepath.Join(cfg.Archive, name)); err != nil {
+			t.Errorf("frame wasn't archived: %v", err)
+		}
+	}
+	if got := u.Uploaded(); len(got) != 1 {
+		t.Errorf("uploaded %v, want only the gif", got)
+	}
+}
+
+func TestWatcherCooldownRewrite(t *testing.T) {
+	_, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := testWatcherConfig(t)
+	cfg.Preserve = true
+	cfg.UploadCooldown = 1500 * time.Millisecond
+	u := &fakeUploader{}
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	writeShot(t, cfg, "shot-1.png")
+	nextEvent(t, events)
+
+	// rewrites within the cooldown are uploaded once it ends
+	p := filepath.Join(cfg.LPath, "shot-1.png")
+	for _, content := range []string{"second", "third version"} {
+		err = os.WriteFile(p, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	ev := nextEvent(t, events)
+	if ev.Err != nil {
+		t.Fatal(ev.Err)
+	}
+
+	// the version uploaded last isn't uploaded again
+	select {
+	case ev := <-events:
+		t.Fatalf("unchanged %s was uploaded again", ev.File.Name)
+	case <-time.After(cfg.UploadCooldown + time.Second):
+	}
+	if got := u.Uploaded(); len(got) != 2 {
+		t.Errorf("uploaded %v, want two versions", got)
+	}
+}
+
+func TestWatcherMetaFile(t *testing.T) {
+	_, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := testWatcherConfig(t)
+	// the .meta file matches the filter too
+	cfg.Filter = `^shot-.*\.png`
+	u := &fakeUploader{}
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	meta := filepath.Join(cfg.LPath, "shot-1.png.meta")
+	err = os.WriteFile(meta, []byte("name: login-bug.png\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeShot(t, cfg, "shot-1.png")
+	ev := nextEvent(t, events)
+	if ev.Err != nil {
+		t.Fatal(ev.Err)
+	}
+	if ev.URL != "https://example.com/login-bug.png" {
+		t.Errorf("URL is %s, want the overridden name", ev.URL)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("%s was uploaded too", ev.File.Name)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := os.Stat(meta); !os.IsNotExist(err) {
+		t.Errorf("the .meta file was left behind: %v", err)
+	}
+}
+
+func TestWatcherLocksBeforeWaitingForNetwork(t *testing.T) {
+	_, _, restore := screenuploadtest.Install()
+	defer restore()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	cfg := testWatcherConfig(t)
+	cfg.SingleInstance = true
+	first, err := screenupload.NewWatcher(cfg, &fakeUploader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startWatcher(t, first)
+
+	// the second instance would wait for the unreachable host first
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	cfg.HostName, cfg.Port = "127.0.0.1", strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	cfg.WaitForNetwork = time.Minute
+	second, err := screenupload.NewWatcher(cfg, &fakeUploader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- second.Start(context.Background())
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "already watching") {
+			t.Errorf("second instance: got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		second.Stop()
+		t.Fatal("second instance waited for the network before taking the lock")
+	}
+}
+
+func TestWatcherProcessExisting(t *testing.T) {
+	_, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := testWatcherConfig(t)
+	cfg.ProcessExisting = true
+	cfg.Dedupe = true
+	cfg.DedupeStore = filepath.Join(t.TempDir(), "uploads.json")
+	cfg.ScanWorkers = 2
+	// two of the files have the same content, the second isn't uploaded
+	files := map[string]string{"shot-a.png": "pixels", "shot-b.png": "other", "shot-c.png": "pixels", "notes.txt": "text"}
+	modTime := time.Now().Add(-time.Hour)
+	for _, name := range []string{"shot-a.png", "shot-b.png", "shot-c.png", "notes.txt"} {
+		p := filepath.Join(cfg.LPath, name)
+		if err := os.WriteFile(p, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime = modTime.Add(time.Minute)
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u := &fakeUploader{}
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	urls := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		ev := nextEvent(t, events)
+		if ev.Err != nil {
+			t.Fatal(ev.Err)
+		}
+		urls[filepath.Base(ev.File.Path)] = ev.URL
+	}
+	if len(urls) != 3 {
+		t.Fatalf("events of %v, want the three existing shots", urls)
+	}
+	if urls["shot-a.png"] != urls["shot-c.png"] {
+		t.Errorf("the duplicate got %s instead of %s", urls["shot-c.png"], urls["shot-a.png"])
+	}
+	if got := u.Uploaded(); len(got) != 2 {
+		t.Errorf("uploaded %v, want two files", got)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.LPath, "notes.txt")); err != nil {
+		t.Errorf("a file not matching FILTER was touched: %v", err)
+	}
+}
+
+func TestWatcherCircuitBreaker(t *testing.T) {
+	_, _, restore := screenuploadtest.Install()
+	defer restore()
+
+	cfg := testWatcherConfig(t)
+	cfg.BreakerFailures = 2
+	cfg.BreakerProbe = 300 * time.Millisecond
+	var down atomic.Bool
+	var calls atomic.Int32
+	down.Store(true)
+	u := &fakeUploader{Fail: func(f screenupload.File) error {
+		calls.Add(1)
+		if down.Load() {
+			return errors.New("server on fire")
+		}
+		return nil
+	}}
+	w, err := screenupload.NewWatcher(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	for _, name := range []string{"shot-1.png", "shot-2.png"} {
+		writeShot(t, cfg, name)
+		if ev := nextEvent(t, events); ev.Err == nil {
+			t.Fatalf("upload of %s didn't fail", ev.File.Name)
+		}
+	}
+
+	// the circuit is open, the new file isn't tried
+	writeShot(t, cfg, "shot-3.png")
+	time.Sleep(100 * time.Millisecond)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("%d uploads tried while the circuit is open, want 2", n)
+	}
+
+	// the probe uploads the held file once the server is back
+	down.Store(false)
+	ev := nextEvent(t, events)
+	if ev.Err != nil || ev.File.Name != "shot-3.png" {
+		t.Fatalf("probe: %s %v", ev.File.Name, ev.Err)
+	}
+	writeShot(t, cfg, "shot-4.png")
+	ev = nextEvent(t, events)
+	if ev.Err != nil || ev.File.Name != "shot-4.png" {
+		t.Fatalf("upload after the probe: %s %v", ev.File.Name, ev.Err)
+	}
+}
+
+func TestWatcherCircuitBreakerIgnoresLocalFailures(t *testing.T) {
+	// restored once the watcher stopped, it notifies about the last failure
+	// after reporting it
+	_, _, restore := screenuploadtest.Install()
+	t.Cleanup(restore)
+
+	// the disk is full, no upload gets to the server
+	cfg := testWatcherConfig(t)
+	cfg.BreakerFailures = 2
+	cfg.BreakerProbe = time.Hour
+	cfg.MinFreeSpace = 1 << 40
+	w, err := screenupload.NewWatcher(cfg, &fakeUploader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := w.Events()
+	startWatcher(t, w)
+
+	// the circuit stays closed, every file is tried
+	for _, name := range []string{"shot-1.png", "shot-2.png", "shot-3.png"} {
+		writeShot(t, cfg, name)
+		if ev := nextEvent(t, events); ev.Err == nil || ev.File.Name != name {
+			t.Fatalf("%s: %s %v, want a failure of %s", name, ev.File.Name, ev.Err, name)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package screenupload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of WebDAV requests which need different fixes
+var (
+	errWebDAVUnauthorized = errors.New("webdav: authentication failed, check WEBDAV_USER and WEBDAV_PASSWORD")
+	errWebDAVForbidden    = errors.New("webdav: access to the remote path is forbidden")
+	errWebDAVFull         = errors.New("webdav: insufficient storage on the server")
+)
+
+// webDAVTimeout bounds a single WebDAV request
+const webDAVTimeout = 5 * time.Minute
+
+// WebDAVUploader uploads files to a WebDAV server with HTTP PUT
+type WebDAVUploader struct {
+	cfg    Config
+	client *http.Client
+
+	mu     sync.Mutex
+	digest *digestChallenge // last digest challenge of the server
+}
+
+// NewWebDAVUploader returns a WebDAVUploader uploading below WebDAVURL
+func NewWebDAVUploader(cfg Config) (*WebDAVUploader, error) {
+	client, err := newHTTPClient(cfg, webDAVTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &WebDAVUploader{cfg: cfg, client: client}, nil
+}
+
+// Upload creates the collections of the remote path and puts the file into it
+func (u *WebDAVUploader) Upload(f File) error {
+	_, err := u.UploadLocation(f)
+	return err
+}
+
+// UploadLocation uploads a file like Upload, with WebDAVUseLocation it
+// returns the Location header of the response resolved against the request
+// URL
+func (u *WebDAVUploader) UploadLocation(f File) (string, error) {
+	dir := strings.Trim(remotePath(u.cfg, f), "/")
+	err := u.mkcol(dir)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	// the file is read again if the request is sent again for digest
+	// authentication
+	req, err := u.request("PUT", strings.TrimPrefix(dir+"/"+f.Name, "/"), io.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = f.Size
+	req.GetBody = func() (io.ReadCloser, error) {
+		_, err := r.Seek(0, io.SeekStart)
+		return io.NopCloser(r), err
+	}
+	resp, err := u.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	default:
+		return "", webDAVError(resp)
+	}
+
+	if !u.cfg.WebDAVUseLocation {
+		return "", nil
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		// no or an invalid Location header
+		return "", nil
+	}
+	return loc.String(), nil
+}
+
+// URL returns the URL of an uploaded file
+func (u *WebDAVUploader) URL(f File) string {
+	return fmt.Sprintf("%s/%s", remoteURL(u.cfg, f), f.Name)
+}
+
+// Remove deletes an uploaded file from the server
+func (u *WebDAVUploader) Remove(f File) error {
+	dir := strings.Trim(remotePath(u.cfg, f), "/")
+	req, err := u.request("DELETE", strings.TrimPrefix(dir+"/"+f.Name, "/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	}
+	return webDAVError(resp)
+}
+
+// Missing returns the files which don't exist on the server
+func (u *WebDAVUploader) Missing(files []File) ([]File, error) {
+	var missing []File
+	for _, f := range files {
+		dir := strings.Trim(remotePath(u.cfg, f), "/")
+		req, err := u.request("HEAD", strings.TrimPrefix(dir+"/"+f.Name, "/"), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := u.do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			missing = append(missing, f)
+		case resp.StatusCode >= 300:
+			return nil, webDAVError(resp)
+		}
+	}
+	return missing, nil
+}
+
+// mkcol creates every collection of dir which doesn't exist yet
+func (u *WebDAVUploader) mkcol(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	var p string
+	for _, c := range strings.Split(dir, "/") {
+		p += c + "/"
+		req, err := u.request("MKCOL", p, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := u.do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		// 405 means the collection exists already
+		case http.StatusCreated, http.StatusMethodNotAllowed:
+			continue
+		}
+		return webDAVError(resp)
+	}
+	return nil
+}
+
+// request returns an authenticated request for a path relative to WebDAVURL
+func (u *WebDAVUploader) request(method, p string, body io.Reader) (*http.Request, error) {
+	segments := strings.Split(p, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(u.cfg.WebDAVURL, "/")+"/"+strings.Join(segments, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+	u.authorize(req)
+	return req, nil
+}
+
+// authorize sets the credentials of WebDAVUser. Digest authentication
+// answers the last challenge of the server, until there is one requests
+// are sent without credentials.
+func (u *WebDAVUploader) authorize(req *http.Request) {
+	if u.cfg.WebDAVUser == "" {
+		return
+	}
+	if u.cfg.WebDAVAuth != "digest" {
+		req.SetBasicAuth(u.cfg.WebDAVUser, u.cfg.WebDAVPassword)
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.digest != nil {
+		u.digest.authorize(req, u.cfg.WebDAVUser, u.cfg.WebDAVPassword)
+	}
+}
+
+// do sends a request, with digest authentication a new challenge of the
+// server is answered by sending the request again
+func (u *WebDAVUploader) do(req *http.Request) (*http.Response, error) {
+	resp, err := u.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || u.cfg.WebDAVUser == "" || u.cfg.WebDAVAuth != "digest" {
+		return resp, err
+	}
+	c, ok := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if !ok || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	u.mu.Lock()
+	u.digest = c
+	u.mu.Unlock()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	u.authorize(retry)
+	return u.client.Do(retry)
+}
+
+// webDAVError returns the error of a failed request
+func webDAVError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return errWebDAVUnauthorized
+	case http.StatusForbidden:
+		return errWebDAVForbidden
+	case http.StatusInsufficientStorage:
+		return errWebDAVFull
+	}
+	return fmt.Errorf("webdav: %s %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status)
+}
@@ -0,0 +1,169 @@
+package screenupload
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testDigestServer is a WebDAV server accepting PUT and MKCOL with digest
+// authentication
+type testDigestServer struct {
+	*httptest.Server
+	algorithm string
+
+	mu    sync.Mutex
+	files map[string][]byte
+	basic bool // a request had basic credentials
+}
+
+func newTestDigestServer(t *testing.T, algorithm string) *testDigestServer {
+	s := &testDigestServer{algorithm: algorithm, files: make(map[string][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testDigestServer) serve(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := r.BasicAuth(); ok {
+		s.mu.Lock()
+		s.basic = true
+		s.mu.Unlock()
+	}
+	if !s.authorized(r) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Add("WWW-Authenticate", `Basic realm="dav"`)
+		w.Header().Add("WWW-Authenticate", `Digest realm="dav", nonce="n0nce", opaque="0paque", qop="auth,auth-int", algorithm=`+s.algorithm)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case "MKCOL":
+		w.WriteHeader(http.StatusCreated)
+	case "PUT":
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.files[r.URL.Path] = b
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized checks the digest response of a request for the user "dav"
+// with the password "secret"
+func (s *testDigestServer) authorized(r *http.Request) bool {
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != "Digest" {
+		return false
+	}
+	p := parseAuthParams(params)
+	var newHash func() hash.Hash = md5.New
+	if s.algorithm == "SHA-256" {
+		newHash = sha256.New
+	}
+	h := func(values ...string) string {
+		h := newHash()
+		h.Write([]byte(strings.Join(values, ":")))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	want := h(h("dav", "dav", "secret"), "n0nce", p["nc"], p["cnonce"], "auth", h(r.Method, r.URL.RequestURI()))
+	return p["username"] == "dav" && p["uri"] == r.URL.RequestURI() && p["opaque"] == "0paque" && p["response"] == want
+}
+
+func TestWebDAVDigestAuth(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "SHA-256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			server := newTestDigestServer(t, algorithm)
+			cfg := DefaultConfig()
+			cfg.Backend = "webdav"
+			cfg.WebDAVURL = server.URL + "/dav"
+			cfg.WebDAVUser = "dav"
+			cfg.WebDAVPassword = "secret"
+			cfg.WebDAVAuth = "digest"
+			cfg.RPath = "shots"
+			cfg.RUrl = "https://example.com/shots"
+
+			u, err := NewUploader(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"one.png", "two.png"} {
+				f, content := testUpload(t, name, 64<<10)
+				info, err := os.Stat(f.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Size = info.Size()
+				err = u.Upload(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				server.mu.Lock()
+				got := server.files[path.Join("/dav/shots", name)]
+				server.mu.Unlock()
+				if string(got) != string(content) {
+					t.Errorf("%s: server has %d bytes, want %d", name, len(got), len(content))
+				}
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if server.basic {
+				t.Error("the password was sent with basic authentication")
+			}
+		})
+	}
+}
+
+func TestWebDAVDigestWrongPassword(t *testing.T) {
+	server := newTestDigestServer(t, "MD5")
+	cfg := DefaultConfig()
+	cfg.WebDAVURL = server.URL
+	cfg.WebDAVUser = "dav"
+	cfg.WebDAVPassword = "wrong"
+	cfg.WebDAVAuth = "digest"
+	cfg.RUrl = "https://example.com"
+
+	u, err := NewWebDAVUploader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := testUpload(t, "shot.png", 16)
+	f.Size = 16
+	if err := u.Upload(f); err != errWebDAVUnauthorized {
+		t.Errorf("got %v, want %v", err, errWebDAVUnauthorized)
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	c, ok := parseDigestChallenge([]string{
+		`Basic realm="dav"`,
+		`Digest realm="a \"quoted\" realm", nonce="abc", qop="auth-int"`,
+		`Digest realm="dav", nonce="md5", qop="auth"`,
+		`Digest realm="dav", nonce="sha", algorithm=SHA-256, qop="auth,auth-int"`,
+		`Digest realm="dav", nonce="unknown", algorithm=SHA-512-256`,
+	})
+	if !ok {
+		t.Fatal("no challenge found")
+	}
+	if c.nonce != "sha" || c.algorithm != "SHA-256" || c.qop != "auth" {
+		t.Errorf("got %+v, want the SHA-256 challenge", c)
+	}
+	if p := parseAuthParams(`realm="a \"quoted\", realm", nonce=abc`); p["realm"] != `a "quoted", realm` || p["nonce"] != "abc" {
+		t.Errorf("got %q", p)
+	}
+}
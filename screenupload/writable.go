@@ -0,0 +1,89 @@
+package screenupload
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// probePrefix is the prefix of the file created to test write access to
+// the remote path
+const probePrefix = ".screenupload-probe-"
+
+// notWritableError explains an upload which failed because the SSH user
+// isn't allowed to write to the remote path
+func notWritableError(cfg Config, dir string, err error) error {
+	return fmt.Errorf("remote path %s not writable by user %s, fix its owner or permissions or change RPATH: %v", dir, cfg.UserName, err)
+}
+
+// permissionDenied reports whether the output of scp, sftp or a shell on
+// the server says writing was denied. A failed login prints "Permission
+// denied (publickey)" and isn't matched.
+func permissionDenied(out string) bool {
+	return strings.Contains(out, "Permission denied") && !strings.Contains(out, "Permission denied (")
+}
+
+// CheckWritable creates and removes a probe file in RPath and VideoRPath to
+// make sure the SSH user can write there before the first upload
+func (u *SCPUploader) CheckWritable() error {
+	dirs := []string{u.cfg.RPath}
+	if u.cfg.VideoRPath != "" && u.cfg.VideoRPath != u.cfg.RPath {
+		dirs = append(dirs, u.cfg.VideoRPath)
+	}
+
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s%x", probePrefix, b)
+
+	u.mu.Lock()
+	systemSSH := u.systemSSH
+	u.mu.Unlock()
+	if systemSSH {
+		for _, dir := range dirs {
+			probe := shellQuote(path.Join(dir, name))
+			_, err := runSystemSSH(u.cfg, fmt.Sprintf(": > %s && rm -f %s", probe, probe))
+			if err != nil && permissionDenied(err.Error()) {
+				return notWritableError(u.cfg, dir, err)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to test write access to %s: %v", dir, err)
+			}
+		}
+		return nil
+	}
+
+	client, release, err := u.connect()
+	if err != nil {
+		return err
+	}
+	defer release()
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp: %v", err)
+	}
+	defer c.Close()
+
+	for _, dir := range dirs {
+		probe := path.Join(dir, name)
+		w, err := c.Create(probe)
+		if os.IsPermission(err) {
+			return notWritableError(u.cfg, dir, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to test write access to %s: %v", dir, err)
+		}
+		w.Close()
+		err = c.Remove(probe)
+		if err != nil {
+			return fmt.Errorf("failed to remove the probe file %s: %v", probe, err)
+		}
+	}
+	return nil
+}
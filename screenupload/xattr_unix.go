@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package screenupload
+
+import "golang.org/x/sys/unix"
+
+// getXattr returns the value of an extended attribute or an empty string
+// if it isn't set
+func getXattr(path, name string) string {
+	buf := make([]byte, 1024)
+	n, err := unix.Getxattr(path, name, buf)
+	if err != nil || n <= 0 {
+		return ""
+	}
+	return string(buf[:n])
+}
@@ -0,0 +1,7 @@
+package screenupload
+
+// getXattr returns an empty string as extended attributes are not
+// supported on windows
+func getXattr(path, name string) string {
+	return ""
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// serviceName is the name of the systemd user unit
+const serviceName = "go-screenupload.service"
+
+// serviceUnit is the systemd user unit, the binary and config path are
+// filled in on install
+const serviceUnit = `[Unit]
+Description=Upload new screenshots and copy their URL
+After=graphical-session.target network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s -config %s
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`
+
+// systemdQuote quotes an argument of ExecStart
+func systemdQuote(s string) string {
+	return strings.Replace(strconv.Quote(s), "%", "%%", -1)
+}
+
+// servicePath returns the path of the user unit
+func servicePath() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", errors.New("systemd services are only supported on Linux")
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", serviceName), nil
+}
+
+// systemctl runs systemctl for the user instance
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// installService writes a systemd user unit starting the binary with the
+// config file, enables and starts it
+func installService(configPath string) error {
+	unit, err := servicePath()
+	if err != nil {
+		return err
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	binary, err = filepath.EvalSymlinks(binary)
+	if err != nil {
+		return err
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(unit), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(unit, []byte(fmt.Sprintf(serviceUnit, systemdQuote(binary), systemdQuote(configPath))), 0644)
+	if err != nil {
+		return err
+	}
+	log.Println("wrote", unit)
+
+	err = systemctl("daemon-reload")
+	if err != nil {
+		return err
+	}
+	err = systemctl("enable", "--now", serviceName)
+	if err != nil {
+		return err
+	}
+	log.Println("enabled and started", serviceName+", see its logs with journalctl --user -u", serviceName)
+	return nil
+}
+
+// uninstallService stops, disables and removes the systemd user unit
+func uninstallService() error {
+	unit, err := servicePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(unit); os.IsNotExist(err) {
+		return fmt.Errorf("no service installed at %s", unit)
+	}
+	err = systemctl("disable", "--now", serviceName)
+	if err != nil {
+		log.Println("warning:", err)
+	}
+	err = os.Remove(unit)
+	if err != nil {
+		return err
+	}
+	log.Println("removed", unit)
+	return systemctl("daemon-reload")
+}
+
+// sdNotify sends a state like READY=1 to systemd if the process is run as
+// a service of type notify
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// abstract sockets are given with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}